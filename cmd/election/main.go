@@ -13,6 +13,23 @@ func main() {
 		fmt.Println("init run command: %w", err)
 		os.Exit(1)
 	}
+	transitionsCmd, err := commands.InitTransitionsCommand()
+	if err != nil {
+		fmt.Println("init transitions command: %w", err)
+		os.Exit(1)
+	}
+	historyCmd, err := commands.InitHistoryCommand()
+	if err != nil {
+		fmt.Println("init history command: %w", err)
+		os.Exit(1)
+	}
+	simulateCmd, err := commands.InitSimulateCommand()
+	if err != nil {
+		fmt.Println("init simulate command: %w", err)
+		os.Exit(1)
+	}
+	rootCmd.AddCommand(&transitionsCmd, &historyCmd, &simulateCmd)
+
 	err = rootCmd.Execute()
 	if err != nil {
 		fmt.Println("run command: %w", err)
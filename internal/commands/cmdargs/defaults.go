@@ -0,0 +1,93 @@
+package cmdargs
+
+import (
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/backoff"
+)
+
+// Defaults of the run flags, WithDefaults applies them to the zero fields.
+var (
+	DefaultBackend          = BackendZookeeper                                // Default coordination backend
+	DefaultEtcdEndpoints    = []string{"localhost:2379"}                      // Default etcd endpoints
+	DefaultZKServers        = []string{"zoo1:2181", "zoo2:2181", "zoo3:2181"} // Default Zookeeper servers
+	DefaultLeaderTimeout    = time.Second * 10                                // Default Leader Timeout
+	DefaultAttempterTimeout = time.Second * 10                                // Default Attempter Timeout
+	DefaultSessionTimeout   = time.Second * 2                                 // Default Session Timeout
+	DefaultFileDir          = "/tmp/election"                                 // Default File Directory
+	DefaultStorageCapacity  = 40                                              // Default Storage Capacity
+	DefaultZKEphemeralPath  = "/app_ephemeral"
+	DefaultK8sNamespace     = "default"        // Default namespace of the Lease
+	DefaultK8sLeaseName     = "election"       // Default name of the Lease
+	DefaultLeaseDuration    = time.Second * 15 // Default Lease duration
+	DefaultRenewDeadline    = time.Second * 10 // Default Lease renew deadline
+	DefaultRetryPeriod      = time.Second * 2  // Default Lease retry period
+	DefaultPgDSN            = "postgres://localhost:5432/election"
+	DefaultPgMode           = "advisory"
+	DefaultPgTable          = "election_leases"
+	DefaultRedisAddr        = "localhost:6379"
+	DefaultRaftBind         = "127.0.0.1:7000"
+	DefaultLeaderTasks      = []string{LeaderTaskFile}    // Default work of the leader
+	DefaultExecGracePeriod  = time.Second * 5             // Default time the child gets between SIGTERM and SIGKILL
+	DefaultExecRestartDelay = time.Second                 // Default initial delay before the child is restarted
+	DefaultHookTimeout      = time.Second * 5             // Default timeout of a single hook call
+	DefaultHookRetries      = 3                           // Default number of hook retries
+	DefaultReadyMode        = "connected"                 // Default condition of the readiness probe
+	DefaultStuckTimeout     = time.Minute                 // Default time after which Init or Stopping fails the liveness probe
+	DefaultFailoverTimeout  = time.Minute * 2             // Default time after which Failover fails the liveness probe
+	DefaultFailoverBackoff  = backoff.StrategyExponential // Default reconnect backoff strategy
+	DefaultFailoverDelay    = time.Second                 // Default initial reconnect delay
+	DefaultFailoverMaxDelay = time.Second * 30            // Default cap of the reconnect delay
+	DefaultShutdownTimeout  = time.Second * 10            // Default time the leader work and the release get on shutdown
+)
+
+// WithDefaults fills the zero fields that have no meaning of their own with
+// the defaults of the flags. Leader tasks are not defaulted, a library user
+// brings its own.
+func (a RunArgs) WithDefaults() RunArgs {
+	setString := func(v *string, def string) {
+		if *v == "" {
+			*v = def
+		}
+	}
+	setStrings := func(v *[]string, def []string) {
+		if len(*v) == 0 {
+			*v = append([]string(nil), def...)
+		}
+	}
+	setDuration := func(v *time.Duration, def time.Duration) {
+		if *v == 0 {
+			*v = def
+		}
+	}
+
+	setString(&a.Backend, DefaultBackend)
+	setStrings(&a.ZkServers, DefaultZKServers)
+	setStrings(&a.EtcdEndpoints, DefaultEtcdEndpoints)
+	setDuration(&a.LeaderTimeout, DefaultLeaderTimeout)
+	setDuration(&a.AttempterTimeout, DefaultAttempterTimeout)
+	setDuration(&a.SessionTimeout, DefaultSessionTimeout)
+	setString(&a.FileDir, DefaultFileDir)
+	if a.StorageCapacity == 0 {
+		a.StorageCapacity = DefaultStorageCapacity
+	}
+	setString(&a.ZKEphemeralPath, DefaultZKEphemeralPath)
+	setString(&a.K8sNamespace, DefaultK8sNamespace)
+	setString(&a.K8sLeaseName, DefaultK8sLeaseName)
+	setDuration(&a.LeaseDuration, DefaultLeaseDuration)
+	setDuration(&a.RenewDeadline, DefaultRenewDeadline)
+	setDuration(&a.RetryPeriod, DefaultRetryPeriod)
+	setString(&a.PgDSN, DefaultPgDSN)
+	setString(&a.PgMode, DefaultPgMode)
+	setString(&a.PgTable, DefaultPgTable)
+	setString(&a.RedisAddr, DefaultRedisAddr)
+	setString(&a.RaftBind, DefaultRaftBind)
+	setDuration(&a.ExecGracePeriod, DefaultExecGracePeriod)
+	setDuration(&a.ExecRestartDelay, DefaultExecRestartDelay)
+	setDuration(&a.HookTimeout, DefaultHookTimeout)
+	setString(&a.ReadyMode, DefaultReadyMode)
+	setString(&a.FailoverBackoff, DefaultFailoverBackoff)
+	setDuration(&a.FailoverInitialDelay, DefaultFailoverDelay)
+	setDuration(&a.ShutdownTimeout, DefaultShutdownTimeout)
+	return a
+}
@@ -1,15 +1,77 @@
 package cmdargs
 
 import (
+	"strings"
 	"time"
 )
 
+const (
+	BackendZookeeper  = "zookeeper"
+	BackendEtcd       = "etcd"
+	BackendKubernetes = "kubernetes"
+	BackendPostgres   = "postgres"
+	BackendRedis      = "redis"
+	BackendRaft       = "raft"
+	BackendFileLock   = "file"
+)
+
+const (
+	LeaderTaskFile = "file"
+	LeaderTaskExec = "exec"
+)
+
 type RunArgs struct {
+	Backend          string
+	Identity         string
 	ZkServers        []string
+	EtcdEndpoints    []string
 	LeaderTimeout    time.Duration
 	SessionTimeout   time.Duration
 	AttempterTimeout time.Duration
+	AttempterPolling bool
 	FileDir          string
 	StorageCapacity  int
 	ZKEphemeralPath  string
+	K8sNamespace     string
+	K8sLeaseName     string
+	LeaseDuration    time.Duration
+	RenewDeadline    time.Duration
+	RetryPeriod      time.Duration
+	PgDSN            string
+	PgMode           string
+	PgTable          string
+	RedisAddr        string
+	RaftBind         string
+	RaftPeers        []string
+	LockFile         string
+	LeaderTasks      []string
+	Command          []string
+	ExecGracePeriod  time.Duration
+	ExecRestartDelay time.Duration
+	OnLeader         string
+	OnFollower       string
+	// OnEnter and OnLeave hold State=script pairs, see SplitStateHook.
+	OnEnter         []string
+	OnLeave         []string
+	Webhooks        []string
+	HookTimeout     time.Duration
+	HookRetries     int
+	AdminToken      string
+	ReadyMode       string
+	StuckTimeout    time.Duration
+	FailoverTimeout time.Duration
+
+	FailoverBackoff      string
+	FailoverInitialDelay time.Duration
+	FailoverMaxDelay     time.Duration
+	FailoverMaxElapsed   time.Duration
+	FailoverMaxRetries   int
+
+	ShutdownTimeout time.Duration
+}
+
+// SplitStateHook splits a State=script pair of OnEnter or OnLeave.
+func SplitStateHook(spec string) (state, script string, ok bool) {
+	state, script, ok = strings.Cut(spec, "=")
+	return state, script, ok && state != "" && script != ""
 }
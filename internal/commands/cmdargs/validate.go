@@ -0,0 +1,104 @@
+package cmdargs
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/backoff"
+)
+
+// Validate reports every invalid setting at once.
+func (a RunArgs) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
+	}
+	positive := func(name string, d time.Duration) {
+		check(d > 0, "%s must be positive, got %s", name, d)
+	}
+
+	backends := []string{BackendZookeeper, BackendEtcd, BackendKubernetes, BackendPostgres, BackendRedis, BackendRaft, BackendFileLock}
+	check(slices.Contains(backends, a.Backend), "backend must be one of %v, got %q", backends, a.Backend)
+
+	switch a.Backend {
+	case BackendZookeeper:
+		check(len(a.ZkServers) > 0, "zk-servers must not be empty for the %s backend", a.Backend)
+	case BackendEtcd:
+		check(len(a.EtcdEndpoints) > 0, "etcd-endpoints must not be empty for the %s backend", a.Backend)
+	case BackendKubernetes:
+		check(a.K8sNamespace != "", "k8s-namespace must not be empty for the %s backend", a.Backend)
+		check(a.K8sLeaseName != "", "k8s-lease-name must not be empty for the %s backend", a.Backend)
+		check(a.RenewDeadline < a.LeaseDuration, "renew-deadline %s must be less than lease-duration %s", a.RenewDeadline, a.LeaseDuration)
+		// client-go jitters the retries by up to leaderelection.JitterFactor.
+		check(float64(a.RenewDeadline) > 1.2*float64(a.RetryPeriod), "renew-deadline %s must be more than 1.2 times retry-period %s", a.RenewDeadline, a.RetryPeriod)
+	case BackendPostgres:
+		check(a.PgDSN != "", "pg-dsn must not be empty for the %s backend", a.Backend)
+		check(a.PgMode == "advisory" || a.PgMode == "lease", "pg-mode must be advisory or lease, got %q", a.PgMode)
+		check(a.PgTable != "", "pg-table must not be empty for the %s backend", a.Backend)
+	case BackendRedis:
+		check(a.RedisAddr != "", "redis-addr must not be empty for the %s backend", a.Backend)
+	case BackendRaft:
+		check(a.RaftBind != "", "raft-bind must not be empty for the %s backend", a.Backend)
+	}
+
+	if a.Backend != BackendKubernetes && a.Backend != BackendRaft {
+		check(a.ZKEphemeralPath != "", "zk-path must not be empty for the %s backend", a.Backend)
+	}
+
+	positive("leader-timeout", a.LeaderTimeout)
+	positive("attempter-timeout", a.AttempterTimeout)
+	positive("session-timeout", a.SessionTimeout)
+	positive("lease-duration", a.LeaseDuration)
+	positive("renew-deadline", a.RenewDeadline)
+	positive("retry-period", a.RetryPeriod)
+	positive("hook-timeout", a.HookTimeout)
+	positive("shutdown-timeout", a.ShutdownTimeout)
+	check(a.StorageCapacity > 0, "storage-capacity must be positive, got %d", a.StorageCapacity)
+	check(a.HookRetries >= 0, "hook-retries must not be negative, got %d", a.HookRetries)
+	check(a.StuckTimeout >= 0, "stuck-timeout must not be negative, got %s", a.StuckTimeout)
+	check(a.FailoverTimeout >= 0, "failover-timeout must not be negative, got %s", a.FailoverTimeout)
+
+	leaderTasks := []string{LeaderTaskFile, LeaderTaskExec}
+	for _, task := range a.LeaderTasks {
+		check(slices.Contains(leaderTasks, task), "leader-tasks must contain only %v, got %q", leaderTasks, task)
+	}
+	if slices.Contains(a.LeaderTasks, LeaderTaskFile) {
+		check(a.FileDir != "", "file-dir must not be empty for the %s leader task", LeaderTaskFile)
+	}
+	if slices.Contains(a.LeaderTasks, LeaderTaskExec) {
+		check(len(a.Command) > 0, "the %s leader task needs a command after --", LeaderTaskExec)
+		positive("exec-grace-period", a.ExecGracePeriod)
+		positive("exec-restart-delay", a.ExecRestartDelay)
+	}
+
+	stateHooks := func(name string, specs []string) {
+		for _, spec := range specs {
+			state, _, ok := SplitStateHook(spec)
+			check(ok && run.IsState(state), "%s must be State=script with a state of %v, got %q", name, run.StateNames(), spec)
+		}
+	}
+	stateHooks("on-enter", a.OnEnter)
+	stateHooks("on-leave", a.OnLeave)
+
+	for _, webhook := range a.Webhooks {
+		u, err := url.Parse(webhook)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "", "webhook must be an http(s) URL, got %q", webhook)
+	}
+
+	strategies := []string{backoff.StrategyConstant, backoff.StrategyExponential, backoff.StrategyDecorrelated}
+	check(slices.Contains(strategies, a.FailoverBackoff), "failover-backoff must be one of %v, got %q", strategies, a.FailoverBackoff)
+	positive("failover-initial-delay", a.FailoverInitialDelay)
+	check(a.FailoverMaxDelay == 0 || a.FailoverMaxDelay >= a.FailoverInitialDelay, "failover-max-delay %s must be 0 or at least failover-initial-delay %s", a.FailoverMaxDelay, a.FailoverInitialDelay)
+	check(a.FailoverMaxElapsed >= 0, "failover-max-elapsed must not be negative, got %s", a.FailoverMaxElapsed)
+	check(a.FailoverMaxRetries >= 0, "failover-max-retries must not be negative, got %d", a.FailoverMaxRetries)
+
+	check(a.ReadyMode == "connected" || a.ReadyMode == "leader", "ready-mode must be connected or leader, got %q", a.ReadyMode)
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,65 @@
+package commands
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/spf13/cobra"
+)
+
+var defaultHistoryAddr = "http://localhost:8080" // Default address of the metrics server of a running node
+
+func InitHistoryCommand() (cobra.Command, error) {
+	var addr string
+	var asJSON bool
+	cmd := cobra.Command{
+		Use:   "history",
+		Short: "Prints the last state transitions of a running node",
+		Long: `This command fetches the transition history from the metrics server
+		of a running leader election node`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			client := http.Client{Timeout: 5 * time.Second}
+			resp, err := client.Get(strings.TrimSuffix(addr, "/") + "/debug/states")
+			if err != nil {
+				return fmt.Errorf("fetch history: %w", err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != http.StatusOK {
+				return fmt.Errorf("fetch history: unexpected status %s", resp.Status)
+			}
+
+			var entries []run.HistoryEntry
+			err = json.NewDecoder(resp.Body).Decode(&entries)
+			if err != nil {
+				return fmt.Errorf("decode history: %w", err)
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(cmd.OutOrStdout())
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(entries)
+			}
+
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ENTERED\tFROM\tTO\tDURATION\tREASON\tERROR")
+			for _, e := range entries {
+				duration := time.Duration(e.DurationSeconds * float64(time.Second)).Round(time.Millisecond)
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+					e.Entered.Format(time.RFC3339), e.From, e.To, duration, e.Reason, e.Error)
+			}
+			return w.Flush()
+		},
+	}
+
+	cmd.Flags().StringVar(&addr, "addr", defaultHistoryAddr, "Set the address of the metrics server of the node.")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the history as JSON.")
+
+	return cmd, nil
+}
@@ -1,151 +1,283 @@
 package commands
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log/slog"
+	"net/url"
 	"os"
-	"strconv"
+	"os/signal"
+	"slices"
 	"strings"
-	"time"
+	"syscall"
 
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/config"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/depgraph"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/pkg/election"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
-var (
-	defaultZKServers        = []string{"zoo1:2181", "zoo2:2181", "zoo3:2181"} // Default Zookeeper servers
-	defaultLeaderTimeout    = time.Second * 10                                // Default Leader Timeout
-	defaultAttempterTimeout = time.Second * 10                                // Default Attempter Timeout
-	defaultSessionTimeout   = time.Second * 2                                 // Default Session Timeout
-	defaultFileDir          = "/tmp/election"                                 // Default File Directory
-	defaultStorageCapacity  = 40                                              // Default Storage Capacity
-	defaultZKEphemeralPath  = "/app_ephemeral"
-)
+// envPrefix is prepended to the environment variables of the flags.
+const envPrefix = "ELECTION"
+
+// secretFlags are masked when the effective configuration is printed.
+var secretFlags = map[string]bool{
+	"admin-token": true,
+	"pg-dsn":      true,
+}
 
 func InitRunCommand() (cobra.Command, error) {
 	cmdArgs := cmdargs.RunArgs{}
+	var configPath string
 	cmd := cobra.Command{
-		Use:   "run",
+		Use:   "run [-- command [args...]]",
 		Short: "Starts a leader election node",
 		Long: `This command starts the leader election node that connects to zookeeper
-		and starts to try to acquire leadership by creation of ephemeral node`,
-		RunE: func(cmd *cobra.Command, _ []string) error {
+		and starts to try to acquire leadership by creation of ephemeral node.
+		The command after -- is run only while this node is the leader.`,
+		// The election itself is run by the root command, "run" is accepted
+		// as a positional argument for compatibility.
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
 			dg := depgraph.New()
 			logger, err := dg.GetLogger()
 			if err != nil {
 				return fmt.Errorf("get logger: %w", err)
 			}
-			logger.Info("args received",
-				slog.String("zk-servers", strings.Join(cmdArgs.ZkServers, ", ")),
-				slog.Duration("leader-timeout", cmdArgs.LeaderTimeout),
-				slog.Duration("attempter-timeout", cmdArgs.AttempterTimeout),
-				slog.Duration("session-timeout", cmdArgs.SessionTimeout),
-				slog.String("file-dir", cmdArgs.FileDir),
-				slog.Int("storage-capacity", cmdArgs.StorageCapacity),
-			)
 
-			_, err = os.ReadDir(cmdArgs.FileDir)
-			if err != nil {
-				logger.Error(fmt.Sprintf("'file-dir' %s can not be read: %v", cmdArgs.FileDir, err))
-				os.Exit(1)
+			if configPath == "" {
+				configPath = os.Getenv(config.EnvName(envPrefix, "config"))
+			}
+			var command []string
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 && dash < len(args) {
+				command = args[dash:]
 			}
 
-			runner, err := dg.GetRunner()
+			sources, err := resolveRunArgs(cmd.Flags(), &cmdArgs, configPath, command)
 			if err != nil {
-				return fmt.Errorf("get runner: %w", err)
+				return err
+			}
+
+			logEffectiveConfig(logger, cmd, cmdArgs.Command, configPath, sources)
+
+			if slices.Contains(cmdArgs.LeaderTasks, cmdargs.LeaderTaskFile) {
+				_, err = os.ReadDir(cmdArgs.FileDir)
+				if err != nil {
+					logger.Error(fmt.Sprintf("'file-dir' %s can not be read: %v", cmdArgs.FileDir, err))
+					os.Exit(1)
+				}
 			}
 
-			firstState, err := dg.GetInitState(cmdArgs)
+			callbacks := election.Callbacks{
+				OnNewLeader: func(identity string) {
+					logger.Info("new leader observed", slog.String("leader", identity))
+				},
+			}
+			elector, err := election.New(cmdArgs, callbacks,
+				election.WithLogger(logger),
+				election.WithListenAddr(run.DefaultAddr),
+				election.WithRegistry(prometheus.DefaultRegisterer),
+			)
 			if err != nil {
-				return fmt.Errorf("get first state: %w", err)
+				return err
 			}
-			err = runner.Run(cmd.Context(), firstState)
+
+			ctx, cancel := signal.NotifyContext(cmd.Context(), syscall.SIGTERM, syscall.SIGINT)
+			defer cancel()
+			go reloadOnChange(ctx, logger, elector, cmd.Flags(), configPath, command)
+
+			err = elector.Run(ctx)
 			if err != nil {
-				return fmt.Errorf("run states: %w", err)
+				return fmt.Errorf("run election: %w", err)
 			}
 			return nil
 		},
 	}
 
-	// priority: flag -> env -> default
-	cmd.Flags().StringSliceVarP(&(cmdArgs.ZkServers), "zk-servers", "s", []string{}, "Set the zookeeper servers.")
-	cmd.Flags().DurationVarP(&(cmdArgs.LeaderTimeout), "leader-timeout", "l", 0, "Set the frequency at which the leader writes the file to disk.")
-	cmd.Flags().DurationVarP(&(cmdArgs.AttempterTimeout), "attempter-timeout", "a", 0, "Set the frequency with which an attempter tries to become a leader.")
-	cmd.Flags().DurationVarP(&(cmdArgs.SessionTimeout), "session-timeout", "t", 0, "Set the session timeout with zookeeper.")
-	cmd.Flags().StringVarP(&(cmdArgs.FileDir), "file-dir", "f", "", "Set the directory to leader writing files.")
-	cmd.Flags().IntVarP(&(cmdArgs.StorageCapacity), "storage-capacity", "c", 0, "Maximum count of files in 'file-dir'.")
-	cmd.Flags().StringVarP(&(cmdArgs.FileDir), "zk-path", "p", "", "Set the ephemeral directory in zookeeper for leader election.")
-
-	if len(cmdArgs.ZkServers) == 0 {
-		cmdArgs.ZkServers = getEnvStrings("ZK_SERVERS", defaultZKServers)
-	}
+	bindRunFlags(cmd.Flags(), &cmdArgs, &configPath)
 
-	if cmdArgs.LeaderTimeout == 0 {
-		cmdArgs.LeaderTimeout = getEnvDuration("LEADER_TIMEOUT", defaultLeaderTimeout)
-	}
+	return cmd, nil
+}
 
-	if cmdArgs.AttempterTimeout == 0 {
-		cmdArgs.AttempterTimeout = getEnvDuration("ATTEMPTER_TIMEOUT", defaultAttempterTimeout)
+func bindRunFlags(flags *pflag.FlagSet, cmdArgs *cmdargs.RunArgs, configPath *string) {
+	flags.StringVar(configPath, "config", "", "Set the YAML or TOML file with settings named like the flags.")
+	flags.StringVarP(&(cmdArgs.Backend), "backend", "b", cmdargs.DefaultBackend, "Set the coordination backend: zookeeper, etcd, kubernetes, postgres, redis, raft or file.")
+	flags.StringVar(&(cmdArgs.Identity), "identity", "", "Set the name this replica campaigns under, defaults to POD_NAME or the hostname.")
+	flags.StringSliceVarP(&(cmdArgs.ZkServers), "zk-servers", "s", cmdargs.DefaultZKServers, "Set the zookeeper servers.")
+	flags.StringSliceVar(&(cmdArgs.EtcdEndpoints), "etcd-endpoints", cmdargs.DefaultEtcdEndpoints, "Set the etcd endpoints.")
+	flags.DurationVarP(&(cmdArgs.LeaderTimeout), "leader-timeout", "l", cmdargs.DefaultLeaderTimeout, "Set the frequency at which the leader writes the file to disk.")
+	flags.DurationVarP(&(cmdArgs.AttempterTimeout), "attempter-timeout", "a", cmdargs.DefaultAttempterTimeout, "Set the frequency with which an attempter tries to become a leader.")
+	flags.BoolVar(&(cmdArgs.AttempterPolling), "attempter-polling", false, "Re-check the election every 'attempter-timeout' in addition to watching the predecessor node.")
+	flags.DurationVarP(&(cmdArgs.SessionTimeout), "session-timeout", "t", cmdargs.DefaultSessionTimeout, "Set the session timeout with zookeeper.")
+	flags.StringVarP(&(cmdArgs.FileDir), "file-dir", "f", cmdargs.DefaultFileDir, "Set the directory to leader writing files.")
+	flags.IntVarP(&(cmdArgs.StorageCapacity), "storage-capacity", "c", cmdargs.DefaultStorageCapacity, "Maximum count of files in 'file-dir'.")
+	flags.StringVarP(&(cmdArgs.ZKEphemeralPath), "zk-path", "p", cmdargs.DefaultZKEphemeralPath, "Set the election path: the parent node of the ephemeral candidate nodes in zookeeper, created with its parents, the key prefix in etcd and redis, the lease name in postgres.")
+	flags.StringVar(&(cmdArgs.K8sNamespace), "k8s-namespace", cmdargs.DefaultK8sNamespace, "Set the namespace of the Lease used by the kubernetes backend, defaults to POD_NAMESPACE.")
+	flags.StringVar(&(cmdArgs.K8sLeaseName), "k8s-lease-name", cmdargs.DefaultK8sLeaseName, "Set the name of the Lease used by the kubernetes backend.")
+	flags.DurationVar(&(cmdArgs.LeaseDuration), "lease-duration", cmdargs.DefaultLeaseDuration, "Set the duration non-leaders wait before forcing to acquire the Lease.")
+	flags.DurationVar(&(cmdArgs.RenewDeadline), "renew-deadline", cmdargs.DefaultRenewDeadline, "Set the duration the leader retries refreshing the Lease before giving up.")
+	flags.DurationVar(&(cmdArgs.RetryPeriod), "retry-period", cmdargs.DefaultRetryPeriod, "Set the duration between Lease acquire and renew attempts.")
+	flags.StringVar(&(cmdArgs.PgDSN), "pg-dsn", cmdargs.DefaultPgDSN, "Set the connection string used by the postgres backend.")
+	flags.StringVar(&(cmdArgs.PgMode), "pg-mode", cmdargs.DefaultPgMode, "Set the postgres election mode: advisory or lease.")
+	flags.StringVar(&(cmdArgs.PgTable), "pg-table", cmdargs.DefaultPgTable, "Set the lease table used by the postgres backend in lease mode.")
+	flags.StringVar(&(cmdArgs.RedisAddr), "redis-addr", cmdargs.DefaultRedisAddr, "Set the address of the redis server used by the redis backend.")
+	flags.StringVar(&(cmdArgs.RaftBind), "raft-bind", cmdargs.DefaultRaftBind, "Set the address the raft backend listens on, it is also the raft server ID.")
+	flags.StringSliceVar(&(cmdArgs.RaftPeers), "raft-peers", []string{}, "Set the addresses of all raft voters, defaults to 'raft-bind' alone.")
+	flags.StringVar(&(cmdArgs.LockFile), "lock-file", "", "Set the lock file used by the file backend, defaults to 'file-dir' with a .lock suffix.")
+	flags.StringSliceVar(&(cmdArgs.LeaderTasks), "leader-tasks", cmdargs.DefaultLeaderTasks, "Set the tasks the leader runs: file or exec, defaults to exec when a command is given.")
+	flags.DurationVar(&(cmdArgs.ExecGracePeriod), "exec-grace-period", cmdargs.DefaultExecGracePeriod, "Set the time the command gets to exit after SIGTERM before it is killed.")
+	flags.DurationVar(&(cmdArgs.ExecRestartDelay), "exec-restart-delay", cmdargs.DefaultExecRestartDelay, "Set the initial delay before restarting a command that exited while leading.")
+	flags.StringVar(&(cmdArgs.OnLeader), "on-leader", "", "Set the script run when this replica becomes the leader.")
+	flags.StringVar(&(cmdArgs.OnFollower), "on-follower", "", "Set the script run when this replica becomes a follower: on leaving the leader state and on the first attempt after the start.")
+	flags.StringSliceVar(&(cmdArgs.OnEnter), "on-enter", []string{}, "Set State=script pairs, the script is run on entering the state, e.g. FailoverState=/etc/alert.sh.")
+	flags.StringSliceVar(&(cmdArgs.OnLeave), "on-leave", []string{}, "Set State=script pairs, the script is run on leaving the state.")
+	flags.StringSliceVar(&(cmdArgs.Webhooks), "webhook", []string{}, "Set the URLs every state transition is POSTed to as JSON.")
+	flags.DurationVar(&(cmdArgs.HookTimeout), "hook-timeout", cmdargs.DefaultHookTimeout, "Set the timeout of a single hook script run or webhook call.")
+	flags.IntVar(&(cmdArgs.HookRetries), "hook-retries", cmdargs.DefaultHookRetries, "Set how many times a failed hook is retried.")
+	flags.StringVar(&(cmdArgs.AdminToken), "admin-token", "", "Set the bearer token required by the admin API, no auth when empty.")
+	flags.StringVar(&(cmdArgs.ReadyMode), "ready-mode", cmdargs.DefaultReadyMode, "Set when /readyz succeeds: connected (any connected node) or leader.")
+	flags.DurationVar(&(cmdArgs.StuckTimeout), "stuck-timeout", cmdargs.DefaultStuckTimeout, "Set the time in Init or Stopping after which /healthz fails.")
+	flags.DurationVar(&(cmdArgs.FailoverTimeout), "failover-timeout", cmdargs.DefaultFailoverTimeout, "Set the time in Failover after which /healthz fails.")
+	flags.StringVar(&(cmdArgs.FailoverBackoff), "failover-backoff", cmdargs.DefaultFailoverBackoff, "Set the reconnect backoff in Failover: constant, exponential or decorrelated (jitter).")
+	flags.DurationVar(&(cmdArgs.FailoverInitialDelay), "failover-initial-delay", cmdargs.DefaultFailoverDelay, "Set the first reconnect delay in Failover.")
+	flags.DurationVar(&(cmdArgs.FailoverMaxDelay), "failover-max-delay", cmdargs.DefaultFailoverMaxDelay, "Set the cap of the reconnect delay in Failover, 0 for no cap.")
+	flags.DurationVar(&(cmdArgs.FailoverMaxElapsed), "failover-max-elapsed", 0, "Set the time after which Failover gives up and stops, 0 to retry forever.")
+	flags.IntVar(&(cmdArgs.FailoverMaxRetries), "failover-max-retries", 0, "Set the number of reconnects after which Failover gives up and stops, 0 to retry forever.")
+	flags.DurationVar(&(cmdArgs.ShutdownTimeout), "shutdown-timeout", cmdargs.DefaultShutdownTimeout, "Set the time the leader work gets to finish and the leadership to be released on SIGTERM or SIGINT.")
+}
+
+// resolveRunArgs fills cmdArgs, bound to flags, in the order
+// flag -> env -> config file -> default and validates the result.
+func resolveRunArgs(flags *pflag.FlagSet, cmdArgs *cmdargs.RunArgs, configPath string, command []string) (config.Sources, error) {
+	sources, err := config.Load(flags, configPath, envPrefix, "config", "help")
+	if err != nil {
+		return nil, fmt.Errorf("load config: %w", err)
 	}
 
-	if cmdArgs.SessionTimeout == 0 {
-		cmdArgs.SessionTimeout = getEnvDuration("SESSION_TIMEOUT", defaultSessionTimeout)
+	if sources["k8s-namespace"] == config.SourceDefault {
+		// Exposed by the downward API.
+		if namespace := os.Getenv("POD_NAMESPACE"); namespace != "" {
+			cmdArgs.K8sNamespace = namespace
+		}
 	}
 
-	if cmdArgs.FileDir == "" {
-		cmdArgs.FileDir = getEnvString("FILE_DIR", defaultFileDir)
+	if len(command) > 0 {
+		cmdArgs.Command = command
+		if sources["leader-tasks"] == config.SourceDefault {
+			cmdArgs.LeaderTasks = []string{cmdargs.LeaderTaskExec}
+		}
 	}
 
-	if cmdArgs.StorageCapacity == 0 {
-		cmdArgs.StorageCapacity = getEnvInt("STORAGE_CAPACITY", defaultStorageCapacity)
+	err = cmdArgs.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
 	}
+	return sources, nil
+}
 
-	if cmdArgs.ZKEphemeralPath == "" {
-		cmdArgs.ZKEphemeralPath = getEnvString("ZK_PATH", defaultZKEphemeralPath)
+// reloadOnChange resolves the settings again on SIGHUP or when the config
+// file changes and hands them to the elector.
+func reloadOnChange(ctx context.Context, logger *slog.Logger, elector *election.Elector, cmdFlags *pflag.FlagSet, configPath string, command []string) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var changes <-chan struct{}
+	if configPath != "" {
+		var err error
+		changes, err = config.Watch(ctx, configPath)
+		if err != nil {
+			logger.Warn("config file is not watched, reload with SIGHUP", slog.String("error", err.Error()))
+		}
 	}
 
-	return cmd, nil
-}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			logger.Info("SIGHUP received, reloading config")
+		case <-changes:
+			logger.Info("config file changed, reloading config", slog.String("config", configPath))
+		}
 
-func getEnvString(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+		args, err := reloadRunArgs(cmdFlags, configPath, command)
+		if err != nil {
+			elector.ReloadFailed(err)
+			continue
+		}
+		_ = elector.Reload(args)
 	}
-	return value
 }
 
-func getEnvStrings(key string, defaultValue []string) []string {
-	value := os.Getenv(key)
-	if value == "" {
-		return defaultValue
+// reloadRunArgs resolves the settings from scratch, keeping the values given
+// on the command line.
+func reloadRunArgs(cmdFlags *pflag.FlagSet, configPath string, command []string) (cmdargs.RunArgs, error) {
+	var cmdArgs cmdargs.RunArgs
+	var ignoredPath string
+	flags := pflag.NewFlagSet("reload", pflag.ContinueOnError)
+	bindRunFlags(flags, &cmdArgs, &ignoredPath)
+
+	var errs []error
+	cmdFlags.Visit(func(flag *pflag.Flag) {
+		target := flags.Lookup(flag.Name)
+		if target == nil {
+			return
+		}
+		if slice, ok := flag.Value.(pflag.SliceValue); ok {
+			errs = append(errs, target.Value.(pflag.SliceValue).Replace(slice.GetSlice()))
+			target.Changed = true
+			return
+		}
+		errs = append(errs, flags.Set(flag.Name, flag.Value.String()))
+	})
+	if err := errors.Join(errs...); err != nil {
+		return cmdArgs, fmt.Errorf("copy flags: %w", err)
 	}
-	return strings.Split(value, ",")
+
+	_, err := resolveRunArgs(flags, &cmdArgs, configPath, command)
+	return cmdArgs, err
 }
 
-func getEnvDuration(key string, defaultValue time.Duration) time.Duration {
-	valueStr := os.Getenv(key)
-	if valueStr == "" {
-		return defaultValue
-	}
-	value, err := time.ParseDuration(valueStr)
-	if err != nil {
-		fmt.Printf("error parsing duration for %s: %v\n", key, err)
-		return defaultValue
+// logEffectiveConfig prints every setting with secrets masked, along with the
+// settings that did not come from the defaults.
+func logEffectiveConfig(logger *slog.Logger, cmd *cobra.Command, command []string, configPath string, sources config.Sources) {
+	var values, overridden []any
+	for _, name := range sources.Names() {
+		flag := cmd.Flags().Lookup(name)
+
+		value := flag.Value.String()
+		if secretFlags[name] && value != "" {
+			value = maskSecret(name, value)
+		}
+		values = append(values, slog.String(name, value))
+
+		if sources[name] != config.SourceDefault {
+			overridden = append(overridden, slog.String(name, string(sources[name])))
+		}
 	}
-	return value
+
+	logger.Info("effective configuration",
+		slog.String("config", configPath),
+		slog.String("command", strings.Join(command, " ")),
+		slog.Group("settings", values...),
+		slog.Group("sources", overridden...),
+	)
 }
 
-func getEnvInt(key string, defaultValue int) int {
-	valueStr := os.Getenv(key)
-	if valueStr == "" {
-		return defaultValue
-	}
-	value, err := strconv.Atoi(valueStr)
-	if err != nil {
-		fmt.Printf("error parsing int for %s: %v\n", key, err)
-		return defaultValue
+// maskSecret hides the password of a connection URL and every other secret
+// completely.
+func maskSecret(name, value string) string {
+	if name == "pg-dsn" {
+		u, err := url.Parse(value)
+		if err == nil && u.Scheme != "" {
+			return u.Redacted()
+		}
 	}
-	return value
+	return "***"
 }
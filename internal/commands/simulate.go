@@ -0,0 +1,70 @@
+package commands
+
+import (
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/simulate"
+	"github.com/spf13/cobra"
+)
+
+func InitSimulateCommand() (cobra.Command, error) {
+	var cfg simulate.Config
+	var verbose bool
+	cmd := cobra.Command{
+		Use:   "simulate",
+		Short: "Checks the election for split brain in a simulated cluster",
+		Long: `This command runs several replicas in one process against an in-memory
+		coordinator and fake clocks, injects partitions, session expiries, clock
+		skew and slow disks drawn from the seed and fails if two replicas lead
+		at once for longer than the session timeout`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			if !cmd.Flags().Changed("seed") {
+				cfg.Seed = rand.Uint64()
+			}
+			if verbose {
+				cfg.Logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{}))
+			}
+
+			report, err := simulate.Run(cmd.Context(), cfg)
+			if err != nil {
+				return fmt.Errorf("simulate with seed %d: %w", cfg.Seed, err)
+			}
+
+			out := cmd.OutOrStdout()
+			fmt.Fprintf(out, "seed %d: %d faults, %d leaderships\n", report.Seed, len(report.Faults), len(report.Intervals))
+			if verbose {
+				for _, fault := range report.Faults {
+					fmt.Fprintln(out, "fault", fault)
+				}
+				for _, interval := range report.Intervals {
+					fmt.Fprintln(out, "leader", interval)
+				}
+			}
+			for _, violation := range report.Violations {
+				fmt.Fprintln(out, violation)
+			}
+
+			err = report.Err()
+			if err != nil {
+				return fmt.Errorf("%w, replay with --seed %d", err, report.Seed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().Uint64Var(&cfg.Seed, "seed", 0, "Set the seed of the faults, random when not given.")
+	cmd.Flags().IntVar(&cfg.Replicas, "replicas", 3, "Set the number of replicas.")
+	cmd.Flags().DurationVar(&cfg.Duration, "duration", 0, "Set the simulated time, 5m when 0.")
+	cmd.Flags().DurationVar(&cfg.Step, "step", 0, "Set how far the clock moves at once, 100ms when 0.")
+	cmd.Flags().DurationVarP(&cfg.SessionTimeout, "session-timeout", "t", cmdargs.DefaultSessionTimeout, "Set the session timeout, it is also the tolerated overlap of leaderships.")
+	cmd.Flags().DurationVar(&cfg.MaxDiskLatency, "max-disk-latency", 0, "Set the cap of the slow disk faults, half the session timeout when 0.")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Log the replicas and print every fault and leadership.")
+
+	return cmd, nil
+}
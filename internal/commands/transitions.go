@@ -0,0 +1,34 @@
+package commands
+
+import (
+	"fmt"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/spf13/cobra"
+)
+
+func InitTransitionsCommand() (cobra.Command, error) {
+	var format string
+	cmd := cobra.Command{
+		Use:   "transitions",
+		Short: "Prints the transition table of the state machine",
+		Long: `This command prints the allowed transitions of the state machine
+		as a Mermaid state diagram or as a Graphviz digraph`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			switch format {
+			case "mermaid":
+				fmt.Fprint(cmd.OutOrStdout(), run.Transitions.Mermaid())
+			case "dot":
+				fmt.Fprint(cmd.OutOrStdout(), run.Transitions.DOT())
+			default:
+				return fmt.Errorf("unknown format %q", format)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&format, "format", "mermaid", "Set the output format: mermaid or dot.")
+
+	return cmd, nil
+}
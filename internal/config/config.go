@@ -0,0 +1,150 @@
+// Package config resolves the settings of a command from its flags,
+// environment variables and a YAML or TOML configuration file.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+// Source tells where the value of a setting came from.
+type Source string
+
+const (
+	SourceDefault Source = "default"
+	SourceFile    Source = "file"
+	SourceEnv     Source = "env"
+	SourceFlag    Source = "flag"
+)
+
+// Sources maps flag names to the source of their values.
+type Sources map[string]Source
+
+// Load fills every flag that was not set on the command line from the
+// environment variable envPrefix_FLAG_NAME, else from the key named like the
+// flag in the configuration file at path, else leaves its default. The flags
+// named in skip, like the one holding path, are left alone. An empty path
+// skips the file.
+func Load(flags *pflag.FlagSet, path, envPrefix string, skip ...string) (Sources, error) {
+	values, err := readFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	for key := range values {
+		if slices.Contains(skip, key) || flags.Lookup(key) == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q", path, key))
+		}
+	}
+
+	sources := make(Sources)
+	flags.VisitAll(func(flag *pflag.Flag) {
+		if slices.Contains(skip, flag.Name) {
+			return
+		}
+
+		if flag.Changed {
+			sources[flag.Name] = SourceFlag
+			return
+		}
+
+		env := EnvName(envPrefix, flag.Name)
+		if value, ok := os.LookupEnv(env); ok {
+			sources[flag.Name] = SourceEnv
+			if err := flag.Value.Set(value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", env, err))
+			}
+			return
+		}
+
+		if value, ok := values[flag.Name]; ok {
+			sources[flag.Name] = SourceFile
+			str, err := stringify(value)
+			if err == nil {
+				err = flag.Value.Set(str)
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", path, flag.Name, err))
+			}
+			return
+		}
+
+		sources[flag.Name] = SourceDefault
+	})
+
+	return sources, errors.Join(errs...)
+}
+
+// EnvName returns the environment variable of the flag: the flag name in upper
+// case with dashes replaced by underscores, after envPrefix.
+func EnvName(envPrefix, flag string) string {
+	return envPrefix + "_" + strings.ToUpper(strings.ReplaceAll(flag, "-", "_"))
+}
+
+// Names returns the flag names in sources in a stable order.
+func (s Sources) Names() []string {
+	names := make([]string, 0, len(s))
+	for name := range s {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func readFile(path string) (map[string]any, error) {
+	values := make(map[string]any)
+	if path == "" {
+		return values, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config: %w", err)
+	}
+
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &values)
+	case ".toml":
+		err = toml.Unmarshal(data, &values)
+	default:
+		return nil, fmt.Errorf("read config: unsupported format %q, use .yaml, .yml or .toml", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse config %s: %w", path, err)
+	}
+	return values, nil
+}
+
+// stringify renders a value of the file the way it would be given on the
+// command line, lists become comma separated.
+func stringify(value any) (string, error) {
+	switch v := value.(type) {
+	case []any:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			str, err := stringify(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, str)
+		}
+		return strings.Join(items, ","), nil
+	case map[string]any:
+		return "", errors.New("nested tables are not supported")
+	case nil:
+		return "", nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
@@ -0,0 +1,167 @@
+// Package coordinationtest checks that a coordination.Coordinator keeps the
+// contract the election states rely on: one leader per election at a time,
+// leadership that ends with Release and Close, epochs that only grow and a
+// Leader that names the holder. Every backend runs Run against its own
+// server; failure modes particular to a backend stay in its package. Raft is
+// left out: its voters are fixed up front and leadership goes to whichever
+// the cluster elects, not to the first to call Acquire.
+package coordinationtest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+)
+
+const (
+	// acquireTimeout bounds an Acquire that is expected to succeed.
+	acquireTimeout = 10 * time.Second
+	// contendedFor is how long an Acquire against a held election is given
+	// to wrongly succeed.
+	contendedFor = 200 * time.Millisecond
+)
+
+// NewFunc returns a connected coordinator campaigning as identity in the
+// named election. Coordinators of one election compete, coordinators of
+// different elections do not. The backend test owns its coordinators and
+// closes them on cleanup.
+type NewFunc func(t *testing.T, election, identity string) coordination.Coordinator
+
+// Run checks the contract in subtests, each in an election of its own.
+func Run(t *testing.T, newCoordinator NewFunc) {
+	t.Run("NoLeadershipBeforeAcquire", func(t *testing.T) {
+		c := newCoordinator(t, "no-leadership-before-acquire", "first")
+
+		lost(t, c, "before acquire")
+		if err := c.Release(context.Background()); err != nil {
+			t.Fatalf("release without leadership: %v", err)
+		}
+		leader(t, c, "")
+	})
+
+	t.Run("ExclusiveUntilRelease", func(t *testing.T) {
+		first := newCoordinator(t, "exclusive-until-release", "first")
+		second := newCoordinator(t, "exclusive-until-release", "second")
+
+		acquire(t, first)
+		leader(t, second, "first")
+
+		acquired := make(chan error, 1)
+		ctx, cancel := context.WithTimeout(context.Background(), acquireTimeout)
+		defer cancel()
+		go func() { acquired <- second.Acquire(ctx) }()
+
+		select {
+		case err := <-acquired:
+			t.Fatalf("second acquired while first leads: %v", err)
+		case <-time.After(contendedFor):
+		}
+		leading(t, first)
+
+		if err := first.Release(context.Background()); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+		lost(t, first, "after release")
+
+		if err := <-acquired; err != nil {
+			t.Fatalf("acquire second: %v", err)
+		}
+		leading(t, second)
+		leader(t, first, "second")
+		if second.Epoch() <= first.Epoch() {
+			t.Fatalf("epoch %d after takeover, want more than %d", second.Epoch(), first.Epoch())
+		}
+	})
+
+	t.Run("TakeoverAfterClose", func(t *testing.T) {
+		first := newCoordinator(t, "takeover-after-close", "first")
+		second := newCoordinator(t, "takeover-after-close", "second")
+
+		acquire(t, first)
+		if err := first.Close(); err != nil {
+			t.Fatalf("close: %v", err)
+		}
+		lost(t, first, "after close")
+
+		acquire(t, second)
+		if second.Epoch() <= first.Epoch() {
+			t.Fatalf("epoch %d after takeover, want more than %d", second.Epoch(), first.Epoch())
+		}
+	})
+
+	t.Run("EpochGrowsOnReacquire", func(t *testing.T) {
+		c := newCoordinator(t, "epoch-grows-on-reacquire", "first")
+
+		acquire(t, c)
+		epoch := c.Epoch()
+		if err := c.Release(context.Background()); err != nil {
+			t.Fatalf("release: %v", err)
+		}
+
+		acquire(t, c)
+		if c.Epoch() <= epoch {
+			t.Fatalf("epoch %d after re-acquire, want more than %d", c.Epoch(), epoch)
+		}
+	})
+
+	t.Run("AcquireStopsWithContext", func(t *testing.T) {
+		first := newCoordinator(t, "acquire-stops-with-context", "first")
+		second := newCoordinator(t, "acquire-stops-with-context", "second")
+
+		acquire(t, first)
+
+		ctx, cancel := context.WithTimeout(context.Background(), contendedFor)
+		defer cancel()
+		if err := second.Acquire(ctx); err == nil {
+			t.Fatal("second acquired while first leads")
+		}
+		lost(t, second, "after a failed acquire")
+		leading(t, first)
+		leader(t, second, "first")
+	})
+}
+
+func acquire(t *testing.T, c coordination.Coordinator) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), acquireTimeout)
+	defer cancel()
+	if err := c.Acquire(ctx); err != nil {
+		t.Fatalf("acquire: %v", err)
+	}
+	leading(t, c)
+}
+
+func leading(t *testing.T, c coordination.Coordinator) {
+	t.Helper()
+
+	select {
+	case <-c.Lost():
+		t.Fatal("leadership is lost")
+	default:
+	}
+}
+
+func lost(t *testing.T, c coordination.Coordinator, when string) {
+	t.Helper()
+
+	select {
+	case <-c.Lost():
+	default:
+		t.Fatalf("leadership is held %s", when)
+	}
+}
+
+func leader(t *testing.T, c coordination.Coordinator, want string) {
+	t.Helper()
+
+	got, err := c.Leader(context.Background())
+	if err != nil {
+		t.Fatalf("leader: %v", err)
+	}
+	if got != want {
+		t.Fatalf("leader is %q, want %q", got, want)
+	}
+}
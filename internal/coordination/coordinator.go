@@ -0,0 +1,46 @@
+package coordination
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotConnected is returned by operations that need a live session.
+var ErrNotConnected = errors.New("coordinator is not connected")
+
+// Coordinator is a coordination backend the election states run against.
+// Implementations keep the session and the leadership they hold internally,
+// so the states only drive the transitions.
+type Coordinator interface {
+	// Connect opens a new session with the backend, dropping the previous one.
+	Connect(ctx context.Context) error
+	// Connected reports whether the current session is usable.
+	Connected() bool
+	// Acquire blocks until this replica holds leadership, ctx is done or the
+	// session fails.
+	Acquire(ctx context.Context) error
+	// Lost returns a channel that is closed once the leadership obtained by
+	// the last successful Acquire is lost.
+	Lost() <-chan struct{}
+	// Epoch returns the leadership epoch of the last successful Acquire.
+	// Epochs only grow across leaderships of all replicas, so the workload can
+	// fence off writes of a stale leader.
+	Epoch() int64
+	// Leader returns the identity of the current leader of the whole
+	// election, or an empty string when there is none.
+	Leader(ctx context.Context) (string, error)
+	// Release gives leadership and the candidacy up, keeping the session.
+	Release(ctx context.Context) error
+	// Close ends the session.
+	Close() error
+}
+
+// Racing is implemented by coordinators whose candidates race for a lock or
+// lease on every retry instead of queueing for it, so a released leadership
+// may go straight back to the replica that released it. Backends with a
+// queue, like ZooKeeper, etcd and Raft, hand it over to the next candidate by
+// themselves.
+type Racing interface {
+	// Racing reports whether the candidates race for leadership.
+	Racing() bool
+}
@@ -0,0 +1,233 @@
+package etcd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+var _ coordination.Coordinator = &Coordinator{}
+
+type Config struct {
+	Endpoints      []string
+	SessionTimeout time.Duration
+	ElectionPath   string
+	Identity       string
+}
+
+func New(cfg Config, logger *slog.Logger) *Coordinator {
+	lost := make(chan struct{})
+	close(lost)
+
+	return &Coordinator{
+		cfg:    cfg,
+		logger: logger.With("subsystem", "EtcdCoordinator"),
+		lost:   lost,
+	}
+}
+
+// Coordinator elects the leader with concurrency.Election. Every session is
+// bound to a lease kept alive by the client, so a failed keepalive ends the
+// session and with it the leadership.
+type Coordinator struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	client   *clientv3.Client
+	session  *concurrency.Session
+	election *concurrency.Election
+	epoch    int64
+	lost     chan struct{}
+	// stepDown closes lost and stops watching the session, it is set while
+	// we lead.
+	stepDown func()
+}
+
+func (c *Coordinator) Connect(ctx context.Context) error {
+	c.Close()
+
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   c.cfg.Endpoints,
+		DialTimeout: c.cfg.SessionTimeout,
+		// The client context outlives Connect, Close cancels it.
+		Context: context.WithoutCancel(ctx),
+	})
+	if err != nil {
+		return fmt.Errorf("connect to etcd: %w", err)
+	}
+
+	// Only the grant is bound to ctx, the keepalive and the revoke on Close
+	// run on the client context, the session must outlive Connect.
+	lease, err := client.Grant(ctx, int64(leaseTTL(c.cfg.SessionTimeout)))
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("grant etcd lease: %w", err)
+	}
+
+	session, err := concurrency.NewSession(client,
+		concurrency.WithTTL(leaseTTL(c.cfg.SessionTimeout)),
+		concurrency.WithLease(lease.ID),
+	)
+	if err != nil {
+		client.Close()
+		return fmt.Errorf("create etcd session: %w", err)
+	}
+
+	c.mu.Lock()
+	c.client = client
+	c.session = session
+	c.election = concurrency.NewElection(session, c.cfg.ElectionPath)
+	c.mu.Unlock()
+
+	c.logger.Info("etcd session established", slog.Int64("lease", int64(session.Lease())))
+	return nil
+}
+
+func (c *Coordinator) Connected() bool {
+	c.mu.Lock()
+	session := c.session
+	c.mu.Unlock()
+
+	if session == nil {
+		return false
+	}
+
+	select {
+	case <-session.Done():
+		return false
+	default:
+		return true
+	}
+}
+
+func (c *Coordinator) Acquire(ctx context.Context) error {
+	c.mu.Lock()
+	session, election := c.session, c.election
+	c.mu.Unlock()
+
+	if election == nil {
+		return coordination.ErrNotConnected
+	}
+
+	err := election.Campaign(ctx, c.cfg.Identity)
+	if err != nil {
+		return fmt.Errorf("campaign: %w", err)
+	}
+
+	lost := make(chan struct{})
+	stop := make(chan struct{})
+	var once sync.Once
+	stepDown := func() {
+		once.Do(func() {
+			close(stop)
+			close(lost)
+		})
+	}
+
+	c.mu.Lock()
+	// The creation revision of our key is newer than the keys of all
+	// previous leaders.
+	c.lost, c.epoch, c.stepDown = lost, election.Rev(), stepDown
+	c.mu.Unlock()
+
+	go func() {
+		select {
+		case <-stop:
+		case <-session.Done():
+			c.logger.Warn("etcd lease keepalive stopped", slog.Int64("lease", int64(session.Lease())))
+			stepDown()
+		}
+	}()
+
+	c.logger.Info("elected", slog.String("key", election.Key()))
+	return nil
+}
+
+func (c *Coordinator) Lost() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+func (c *Coordinator) Epoch() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+func (c *Coordinator) Leader(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	election := c.election
+	c.mu.Unlock()
+
+	if election == nil {
+		return "", coordination.ErrNotConnected
+	}
+
+	resp, err := election.Leader(ctx)
+	if errors.Is(err, concurrency.ErrElectionNoLeader) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get leader: %w", err)
+	}
+	return string(resp.Kvs[0].Value), nil
+}
+
+// Release gives leadership up before resigning, so that we stop acting as
+// the leader before anyone else can be elected.
+func (c *Coordinator) Release(ctx context.Context) error {
+	c.mu.Lock()
+	election, stepDown := c.election, c.stepDown
+	c.stepDown = nil
+	c.mu.Unlock()
+
+	if stepDown != nil {
+		stepDown()
+	}
+	if election == nil {
+		return nil
+	}
+
+	err := election.Resign(ctx)
+	if err != nil {
+		return fmt.Errorf("resign: %w", err)
+	}
+	return nil
+}
+
+func (c *Coordinator) Close() error {
+	c.mu.Lock()
+	client, session, stepDown := c.client, c.session, c.stepDown
+	c.client, c.session, c.election, c.stepDown = nil, nil, nil, nil
+	c.mu.Unlock()
+
+	if stepDown != nil {
+		stepDown()
+	}
+	if session != nil {
+		// Revoking the lease deletes our election key right away.
+		_ = session.Close()
+	}
+	if client != nil {
+		return client.Close()
+	}
+	return nil
+}
+
+// leaseTTL converts the session timeout into whole lease seconds.
+func leaseTTL(timeout time.Duration) int {
+	ttl := int(timeout.Round(time.Second) / time.Second)
+	if ttl < 1 {
+		return 1
+	}
+	return ttl
+}
@@ -0,0 +1,135 @@
+package etcd
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/coordinationtest"
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/server/v3/embed"
+)
+
+// startEtcd runs a single member etcd in a temporary directory and returns
+// its client URL.
+func startEtcd(t *testing.T) string {
+	t.Helper()
+
+	cfg := embed.NewConfig()
+	cfg.Dir = t.TempDir()
+	cfg.LogLevel = "error"
+	clientURL, _ := url.Parse("http://127.0.0.1:0")
+	peerURL, _ := url.Parse("http://127.0.0.1:0")
+	cfg.ListenClientUrls, cfg.AdvertiseClientUrls = []url.URL{*clientURL}, []url.URL{*clientURL}
+	cfg.ListenPeerUrls, cfg.AdvertisePeerUrls = []url.URL{*peerURL}, []url.URL{*peerURL}
+	cfg.InitialCluster = cfg.Name + "=" + peerURL.String()
+
+	server, err := embed.StartEtcd(cfg)
+	if err != nil {
+		t.Fatalf("start etcd: %v", err)
+	}
+	t.Cleanup(server.Close)
+
+	select {
+	case <-server.Server.ReadyNotify():
+	case <-time.After(10 * time.Second):
+		t.Fatal("etcd did not become ready")
+	}
+	return server.Clients[0].Addr().String()
+}
+
+func newTestCoordinator(t *testing.T, endpoint, electionPath, identity string) *Coordinator {
+	t.Helper()
+
+	c := New(Config{
+		Endpoints:      []string{endpoint},
+		SessionTimeout: time.Second,
+		ElectionPath:   electionPath,
+		Identity:       identity,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { _ = c.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connect %s: %v", identity, err)
+	}
+	return c
+}
+
+func acquire(t *testing.T, c *Coordinator) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := c.Acquire(ctx); err != nil {
+		t.Fatalf("acquire %s: %v", c.cfg.Identity, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	endpoint := startEtcd(t)
+	coordinationtest.Run(t, func(t *testing.T, election, identity string) coordination.Coordinator {
+		return newTestCoordinator(t, endpoint, "/"+election, identity)
+	})
+}
+
+func TestCloseRevokesLeadership(t *testing.T) {
+	endpoint := startEtcd(t)
+	first := newTestCoordinator(t, endpoint, "/election", "first")
+	second := newTestCoordinator(t, endpoint, "/election", "second")
+
+	acquire(t, first)
+	if err := first.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	select {
+	case <-first.Lost():
+	default:
+		t.Fatal("leadership is kept after close")
+	}
+
+	// The lease is revoked, the follower does not wait for its TTL.
+	start := time.Now()
+	acquire(t, second)
+	if waited := time.Since(start); waited > 500*time.Millisecond {
+		t.Fatalf("takeover took %v", waited)
+	}
+}
+
+func TestLostWhenSessionExpires(t *testing.T) {
+	endpoint := startEtcd(t)
+	first := newTestCoordinator(t, endpoint, "/election", "first")
+	second := newTestCoordinator(t, endpoint, "/election", "second")
+
+	acquire(t, first)
+
+	// The server revokes a lease whose keepalives stop for its TTL, doing it
+	// from another client skips the wait.
+	admin, err := clientv3.New(clientv3.Config{Endpoints: []string{endpoint}})
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	defer admin.Close()
+	if _, err := admin.Revoke(context.Background(), first.session.Lease()); err != nil {
+		t.Fatalf("revoke: %v", err)
+	}
+
+	select {
+	case <-first.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership kept after the session expired")
+	}
+	if first.Connected() {
+		t.Fatal("connected with an expired session")
+	}
+
+	acquire(t, second)
+	if second.Epoch() <= first.Epoch() {
+		t.Fatalf("epoch %d after takeover, want more than %d", second.Epoch(), first.Epoch())
+	}
+}
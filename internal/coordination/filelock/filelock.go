@@ -0,0 +1,256 @@
+package filelock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+)
+
+var (
+	_ coordination.Coordinator = &Coordinator{}
+	_ coordination.Racing      = &Coordinator{}
+)
+
+type Config struct {
+	Path        string
+	Identity    string
+	RetryPeriod time.Duration
+}
+
+func New(cfg Config, logger *slog.Logger) *Coordinator {
+	lost := make(chan struct{})
+	close(lost)
+
+	return &Coordinator{
+		cfg:    cfg,
+		logger: logger.With("subsystem", "FileLockCoordinator"),
+		lost:   lost,
+	}
+}
+
+// Coordinator elects the leader among processes of one host with an
+// exclusive lock on a shared lock file. The lock dies with the process, just
+// like an ephemeral node dies with the session.
+type Coordinator struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	file   *os.File
+	epoch  int64
+	lost   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (c *Coordinator) Connect(context.Context) error {
+	c.Close()
+
+	file, err := os.OpenFile(c.cfg.Path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("open lock file: %w", err)
+	}
+
+	c.mu.Lock()
+	c.file = file
+	c.mu.Unlock()
+
+	c.logger.Info("lock file opened", slog.String("path", c.cfg.Path))
+	return nil
+}
+
+func (c *Coordinator) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.file != nil
+}
+
+func (c *Coordinator) Acquire(ctx context.Context) error {
+	c.mu.Lock()
+	file := c.file
+	c.mu.Unlock()
+
+	if file == nil {
+		return coordination.ErrNotConnected
+	}
+
+	for {
+		locked, err := tryLock(file)
+		if err != nil {
+			return fmt.Errorf("lock %s: %w", c.cfg.Path, err)
+		}
+		if locked {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.cfg.RetryPeriod):
+		}
+	}
+
+	// Only the lock holder touches the file, so bumping the epoch stored in it
+	// is race free.
+	epoch := readEpoch(file) + 1
+	err := writeHolder(file, epoch, c.cfg.Identity)
+	if err != nil {
+		_ = unlock(file)
+		return fmt.Errorf("record lock holder: %w", err)
+	}
+
+	lost := make(chan struct{})
+	watchCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	c.mu.Lock()
+	c.lost, c.cancel, c.done, c.epoch = lost, cancel, done, epoch
+	c.mu.Unlock()
+
+	go c.watch(watchCtx, file, lost, done)
+
+	c.logger.Info("elected", slog.String("path", c.cfg.Path))
+	return nil
+}
+
+func (c *Coordinator) Lost() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+func (c *Coordinator) Epoch() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+// Leader probes the lock through a separate descriptor, which conflicts even
+// with a lock held by this very process, and reads the holder recorded in
+// the file.
+func (c *Coordinator) Leader(context.Context) (string, error) {
+	file, err := os.Open(c.cfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("open lock file: %w", err)
+	}
+	defer file.Close()
+
+	free, err := tryLock(file)
+	if err != nil {
+		return "", fmt.Errorf("probe lock %s: %w", c.cfg.Path, err)
+	}
+	if free {
+		return "", unlock(file)
+	}
+
+	var epoch int64
+	var identity string
+	_, err = fmt.Fscanf(file, "epoch=%d %s", &epoch, &identity)
+	if err != nil {
+		return "", fmt.Errorf("read lock holder: %w", err)
+	}
+	return identity, nil
+}
+
+func (c *Coordinator) Release(context.Context) error {
+	c.mu.Lock()
+	file, cancel, done := c.file, c.cancel, c.done
+	c.cancel, c.done = nil, nil
+	c.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-done
+
+	err := unlock(file)
+	if err != nil {
+		return fmt.Errorf("unlock %s: %w", c.cfg.Path, err)
+	}
+	return nil
+}
+
+// Racing is true, candidates retry flock and the first one after a release
+// takes the file.
+func (c *Coordinator) Racing() bool {
+	return true
+}
+
+func (c *Coordinator) Close() error {
+	err := c.Release(context.Background())
+
+	c.mu.Lock()
+	file := c.file
+	c.file = nil
+	c.mu.Unlock()
+
+	if file != nil {
+		return errors.Join(err, file.Close())
+	}
+	return err
+}
+
+// watch closes lost once the lock file is removed or replaced, since another
+// process could then lock the new file while we hold the old one.
+func (c *Coordinator) watch(ctx context.Context, file *os.File, lost, done chan struct{}) {
+	defer close(done)
+	defer close(lost)
+
+	ticker := time.NewTicker(c.cfg.RetryPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		held, err := file.Stat()
+		if err != nil {
+			c.logger.Warn("can not stat held lock file", slog.String("error", err.Error()))
+			return
+		}
+
+		current, err := os.Stat(c.cfg.Path)
+		if err != nil || !os.SameFile(held, current) {
+			c.logger.Warn("lock file was removed or replaced", slog.String("path", c.cfg.Path))
+			return
+		}
+	}
+}
+
+// readEpoch returns the epoch of the previous holder, zero for a new file.
+func readEpoch(file *os.File) int64 {
+	buf := make([]byte, 64)
+	n, _ := file.ReadAt(buf, 0)
+
+	var epoch int64
+	_, err := fmt.Sscanf(string(buf[:n]), "epoch=%d", &epoch)
+	if err != nil {
+		return 0
+	}
+	return epoch
+}
+
+func writeHolder(file *os.File, epoch int64, identity string) error {
+	err := file.Truncate(0)
+	if err != nil {
+		return err
+	}
+	_, err = file.WriteAt([]byte(fmt.Sprintf("epoch=%d %s pid=%d\n", epoch, identity, os.Getpid())), 0)
+	if err != nil {
+		return err
+	}
+	return file.Sync()
+}
@@ -0,0 +1,124 @@
+//go:build unix
+
+package filelock
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/coordinationtest"
+)
+
+const testRetryPeriod = 20 * time.Millisecond
+
+// newTestCoordinator opens its own descriptor of path, so two coordinators in
+// one process conflict on flock like two processes would.
+func newTestCoordinator(t *testing.T, path, identity string) *Coordinator {
+	t.Helper()
+
+	c := New(Config{
+		Path:        path,
+		Identity:    identity,
+		RetryPeriod: testRetryPeriod,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { _ = c.Close() })
+
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("connect %s: %v", identity, err)
+	}
+	return c
+}
+
+func acquire(t *testing.T, c *Coordinator) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Acquire(ctx); err != nil {
+		t.Fatalf("acquire %s: %v", c.cfg.Identity, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	dir := t.TempDir()
+	coordinationtest.Run(t, func(t *testing.T, election, identity string) coordination.Coordinator {
+		return newTestCoordinator(t, filepath.Join(dir, election), identity)
+	})
+}
+
+func TestSecondWaitsForRelease(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "election.lock")
+	first := newTestCoordinator(t, path, "first")
+	second := newTestCoordinator(t, path, "second")
+
+	acquire(t, first)
+	if first.Epoch() != 1 {
+		t.Fatalf("first epoch is %d, want 1", first.Epoch())
+	}
+	if leader, err := second.Leader(context.Background()); err != nil || leader != "first" {
+		t.Fatalf("leader is %q, %v, want first", leader, err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		acquired <- second.Acquire(ctx)
+	}()
+
+	select {
+	case err := <-acquired:
+		t.Fatalf("second acquired a held lock: %v", err)
+	case <-time.After(5 * testRetryPeriod):
+	}
+
+	if err := first.Release(context.Background()); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if err := <-acquired; err != nil {
+		t.Fatalf("acquire second: %v", err)
+	}
+	if second.Epoch() != 2 {
+		t.Fatalf("second epoch is %d, want 2", second.Epoch())
+	}
+	if leader, err := first.Leader(context.Background()); err != nil || leader != "second" {
+		t.Fatalf("leader is %q, %v, want second", leader, err)
+	}
+}
+
+func TestCloseFreesLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "election.lock")
+	first := newTestCoordinator(t, path, "first")
+	second := newTestCoordinator(t, path, "second")
+
+	acquire(t, first)
+	if err := first.Close(); err != nil {
+		t.Fatalf("close: %v", err)
+	}
+	if leader, err := second.Leader(context.Background()); err != nil || leader != "" {
+		t.Fatalf("leader is %q, %v, want none", leader, err)
+	}
+	acquire(t, second)
+}
+
+func TestLostWhenFileRemoved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "election.lock")
+	first := newTestCoordinator(t, path, "first")
+
+	acquire(t, first)
+	if err := os.Remove(path); err != nil {
+		t.Fatalf("remove lock file: %v", err)
+	}
+
+	select {
+	case <-first.Lost():
+	case <-time.After(50 * testRetryPeriod):
+		t.Fatal("leadership kept after the lock file was removed")
+	}
+}
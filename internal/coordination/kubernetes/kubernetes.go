@@ -0,0 +1,291 @@
+package kubernetes
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+var (
+	_ coordination.Coordinator = &Coordinator{}
+	_ coordination.Racing      = &Coordinator{}
+)
+
+type Config struct {
+	// Client is used as is when set, otherwise the in-cluster config is
+	// loaded on Connect.
+	Client        kubernetes.Interface
+	Namespace     string
+	LeaseName     string
+	Identity      string
+	LeaseDuration time.Duration
+	RenewDeadline time.Duration
+	RetryPeriod   time.Duration
+}
+
+func New(cfg Config, logger *slog.Logger) *Coordinator {
+	lost := make(chan struct{})
+	close(lost)
+
+	return &Coordinator{
+		cfg:    cfg,
+		logger: logger.With("subsystem", "KubernetesCoordinator"),
+		lost:   lost,
+	}
+}
+
+// Coordinator elects the leader through a coordination.k8s.io Lease using
+// the client-go leaderelection package.
+type Coordinator struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	client kubernetes.Interface
+	epoch  int64
+	lost   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (c *Coordinator) Connect(ctx context.Context) error {
+	c.Close()
+
+	client := c.cfg.Client
+	if client == nil {
+		restConfig, err := rest.InClusterConfig()
+		if err != nil {
+			return fmt.Errorf("load in-cluster config: %w", err)
+		}
+
+		client, err = kubernetes.NewForConfig(restConfig)
+		if err != nil {
+			return fmt.Errorf("create kubernetes client: %w", err)
+		}
+	}
+
+	_, err := client.CoordinationV1().Leases(c.cfg.Namespace).Get(ctx, c.cfg.LeaseName, metav1.GetOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("get lease %s/%s: %w", c.cfg.Namespace, c.cfg.LeaseName, err)
+	}
+
+	c.mu.Lock()
+	c.client = client
+	c.mu.Unlock()
+
+	c.logger.Info("kubernetes client ready",
+		slog.String("lease", c.cfg.Namespace+"/"+c.cfg.LeaseName),
+		slog.String("identity", c.cfg.Identity),
+	)
+	return nil
+}
+
+func (c *Coordinator) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client != nil
+}
+
+func (c *Coordinator) Acquire(ctx context.Context) error {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+
+	if client == nil {
+		return coordination.ErrNotConnected
+	}
+
+	started := make(chan struct{})
+	lost := make(chan struct{})
+
+	lock := &fencedLock{LeaseLock: &resourcelock.LeaseLock{
+		LeaseMeta: metav1.ObjectMeta{
+			Name:      c.cfg.LeaseName,
+			Namespace: c.cfg.Namespace,
+		},
+		Client:     client.CoordinationV1(),
+		LockConfig: resourcelock.ResourceLockConfig{Identity: c.cfg.Identity},
+	}}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   c.cfg.LeaseDuration,
+		RenewDeadline:   c.cfg.RenewDeadline,
+		RetryPeriod:     c.cfg.RetryPeriod,
+		ReleaseOnCancel: true,
+		Name:            c.cfg.LeaseName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) { close(started) },
+			OnStoppedLeading: func() { close(lost) },
+			OnNewLeader: func(identity string) {
+				c.logger.Info("observed leader", slog.String("identity", identity))
+			},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("create leader elector: %w", err)
+	}
+
+	// The elector outlives Acquire, so it runs on its own context that only
+	// Release and Close cancel.
+	electorCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		elector.Run(electorCtx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		cancel()
+		<-done
+		return ctx.Err()
+
+	case <-started:
+		// The epoch is the record written on acquisition, a later read
+		// could already see the lease of the next leader.
+		epoch := lock.epoch()
+
+		c.mu.Lock()
+		c.lost, c.cancel, c.done, c.epoch = lost, cancel, done, epoch
+		c.mu.Unlock()
+		return nil
+	}
+}
+
+func (c *Coordinator) Lost() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+func (c *Coordinator) Epoch() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+func (c *Coordinator) Leader(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+
+	if client == nil {
+		return "", coordination.ErrNotConnected
+	}
+
+	lease, err := client.CoordinationV1().Leases(c.cfg.Namespace).Get(ctx, c.cfg.LeaseName, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get lease %s/%s: %w", c.cfg.Namespace, c.cfg.LeaseName, err)
+	}
+	if lease.Spec.HolderIdentity == nil {
+		return "", nil
+	}
+	return *lease.Spec.HolderIdentity, nil
+}
+
+func (c *Coordinator) Release(context.Context) error {
+	c.mu.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel, c.done = nil, nil
+	c.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+
+	// ReleaseOnCancel makes the elector clear the holder identity on exit.
+	cancel()
+	<-done
+	return nil
+}
+
+// Racing is true, every elector retries the lease and the first one after a
+// release takes it.
+func (c *Coordinator) Racing() bool {
+	return true
+}
+
+func (c *Coordinator) Close() error {
+	err := c.Release(context.Background())
+
+	c.mu.Lock()
+	c.client = nil
+	c.mu.Unlock()
+
+	return err
+}
+
+// fencedLock makes LeaseTransitions a fencing token. The elector only bumps it
+// when the holder changes, so a replica that re-acquires under the same
+// identity, e.g. after a restart, would keep the epoch of its predecessor.
+// The first write of every elector, which is its acquisition, bumps it past
+// the last observed value instead, and the later writes keep it.
+type fencedLock struct {
+	*resourcelock.LeaseLock
+
+	mu       sync.Mutex
+	observed *int
+	written  int
+	acquired bool
+}
+
+func (l *fencedLock) Get(ctx context.Context) (*resourcelock.LeaderElectionRecord, []byte, error) {
+	record, raw, err := l.LeaseLock.Get(ctx)
+	if err == nil {
+		l.mu.Lock()
+		transitions := record.LeaderTransitions
+		l.observed = &transitions
+		l.mu.Unlock()
+	}
+	return record, raw, err
+}
+
+func (l *fencedLock) Create(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	return l.write(ctx, ler, l.LeaseLock.Create)
+}
+
+func (l *fencedLock) Update(ctx context.Context, ler resourcelock.LeaderElectionRecord) error {
+	return l.write(ctx, ler, l.LeaseLock.Update)
+}
+
+func (l *fencedLock) write(ctx context.Context, ler resourcelock.LeaderElectionRecord, do func(context.Context, resourcelock.LeaderElectionRecord) error) error {
+	l.mu.Lock()
+	switch {
+	case l.acquired:
+		ler.LeaderTransitions = l.written
+	case l.observed != nil:
+		ler.LeaderTransitions = max(ler.LeaderTransitions, *l.observed+1)
+	}
+	l.mu.Unlock()
+
+	err := do(ctx, ler)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	l.written, l.acquired = ler.LeaderTransitions, true
+	l.mu.Unlock()
+	return nil
+}
+
+// epoch returns the transitions written on acquisition.
+func (l *fencedLock) epoch() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return int64(l.written)
+}
@@ -0,0 +1,155 @@
+package kubernetes
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/coordinationtest"
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	testNamespace = "default"
+	testLease     = "election"
+)
+
+func newTestCoordinator(t *testing.T, client *fake.Clientset, identity string) *Coordinator {
+	t.Helper()
+	return newTestCoordinatorFor(t, client, testLease, identity)
+}
+
+func newTestCoordinatorFor(t *testing.T, client *fake.Clientset, leaseName, identity string) *Coordinator {
+	t.Helper()
+
+	c := New(Config{
+		Client:        client,
+		Namespace:     testNamespace,
+		LeaseName:     leaseName,
+		Identity:      identity,
+		LeaseDuration: time.Second,
+		RenewDeadline: 500 * time.Millisecond,
+		RetryPeriod:   100 * time.Millisecond,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { _ = c.Close() })
+
+	err := c.Connect(context.Background())
+	if err != nil {
+		t.Fatalf("connect %s: %v", identity, err)
+	}
+	return c
+}
+
+func acquire(t *testing.T, c *Coordinator) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	err := c.Acquire(ctx)
+	if err != nil {
+		t.Fatalf("acquire %s: %v", c.cfg.Identity, err)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	coordinationtest.Run(t, func(t *testing.T, election, identity string) coordination.Coordinator {
+		return newTestCoordinatorFor(t, client, election, identity)
+	})
+}
+
+func TestEpochGrowsOnReacquireBySameIdentity(t *testing.T) {
+	// A replica restarted under the same identity finds its own lease.
+	client := fake.NewSimpleClientset(&coordinationv1.Lease{
+		ObjectMeta: metav1.ObjectMeta{Name: testLease, Namespace: testNamespace},
+		Spec: coordinationv1.LeaseSpec{
+			HolderIdentity:       ptr.To("replica"),
+			LeaseDurationSeconds: ptr.To[int32](1),
+			AcquireTime:          &metav1.MicroTime{Time: time.Now()},
+			RenewTime:            &metav1.MicroTime{Time: time.Now()},
+			LeaseTransitions:     ptr.To[int32](5),
+		},
+	})
+	c := newTestCoordinator(t, client, "replica")
+
+	acquire(t, c)
+	if c.Epoch() != 6 {
+		t.Fatalf("epoch %d, want 6", c.Epoch())
+	}
+
+	lease, err := client.CoordinationV1().Leases(testNamespace).Get(context.Background(), testLease, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease: %v", err)
+	}
+	if got := *lease.Spec.LeaseTransitions; got != 6 {
+		t.Fatalf("lease transitions %d, want 6", got)
+	}
+
+	if err := c.Release(context.Background()); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	acquire(t, c)
+	if c.Epoch() != 7 {
+		t.Fatalf("epoch %d after re-acquire, want 7", c.Epoch())
+	}
+}
+
+func TestRenewKeepsEpoch(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := newTestCoordinator(t, client, "replica")
+
+	acquire(t, c)
+	epoch := c.Epoch()
+
+	// A few renewals later the lease still carries the epoch of the
+	// acquisition.
+	time.Sleep(500 * time.Millisecond)
+	lease, err := client.CoordinationV1().Leases(testNamespace).Get(context.Background(), testLease, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease: %v", err)
+	}
+	if got := int64(*lease.Spec.LeaseTransitions); got != epoch {
+		t.Fatalf("lease transitions %d after renewals, want %d", got, epoch)
+	}
+	select {
+	case <-c.Lost():
+		t.Fatal("leadership lost while renewing")
+	default:
+	}
+}
+
+func TestLostWhenRenewConflicts(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	c := newTestCoordinator(t, client, "replica")
+
+	acquire(t, c)
+
+	// Another writer takes the lease over, as after a partition that outlived
+	// our lease, and every renewal from now on finds it held.
+	leases := client.CoordinationV1().Leases(testNamespace)
+	lease, err := leases.Get(context.Background(), testLease, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("get lease: %v", err)
+	}
+	lease.Spec.HolderIdentity = ptr.To("intruder")
+	lease.Spec.RenewTime = &metav1.MicroTime{Time: time.Now().Add(time.Hour)}
+	if _, err := leases.Update(context.Background(), lease, metav1.UpdateOptions{}); err != nil {
+		t.Fatalf("update lease: %v", err)
+	}
+
+	select {
+	case <-c.Lost():
+	case <-time.After(2 * c.cfg.LeaseDuration):
+		t.Fatal("leadership kept after the lease was taken")
+	}
+	if leader, err := c.Leader(context.Background()); err != nil || leader != "intruder" {
+		t.Fatalf("leader is %q, %v, want intruder", leader, err)
+	}
+}
@@ -0,0 +1,428 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/jackc/pgx/v5"
+)
+
+var (
+	_ coordination.Coordinator = &Coordinator{}
+	_ coordination.Racing      = &Coordinator{}
+)
+
+const (
+	// ModeAdvisoryLock holds pg_try_advisory_lock on a dedicated session.
+	ModeAdvisoryLock = "advisory"
+	// ModeLeaseTable keeps a lease row with an expiry column, for poolers
+	// that do not preserve session-level locks.
+	ModeLeaseTable = "lease"
+)
+
+type Config struct {
+	DSN          string
+	Mode         string
+	ElectionPath string
+	Table        string
+	Identity     string
+	LeaseTTL     time.Duration
+	RetryPeriod  time.Duration
+}
+
+func New(cfg Config, logger *slog.Logger) *Coordinator {
+	lost := make(chan struct{})
+	close(lost)
+
+	return &Coordinator{
+		cfg:    cfg,
+		logger: logger.With("subsystem", "PostgresCoordinator"),
+		lockID: lockID(cfg.ElectionPath),
+		lost:   lost,
+	}
+}
+
+// Coordinator elects the leader either with a session advisory lock or with a
+// lease row, both keyed by the election path.
+type Coordinator struct {
+	cfg    Config
+	logger *slog.Logger
+	lockID int64
+
+	// connMu serializes queries, pgx.Conn is not safe for concurrent use.
+	connMu sync.Mutex
+	conn   *pgx.Conn
+
+	mu     sync.Mutex
+	epoch  int64
+	lost   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (c *Coordinator) Connect(ctx context.Context) error {
+	c.Close()
+
+	connConfig, err := pgx.ParseConfig(c.cfg.DSN)
+	if err != nil {
+		return fmt.Errorf("parse postgres dsn: %w", err)
+	}
+	// Advisory locks carry no owner, the application name tells who holds one.
+	connConfig.RuntimeParams["application_name"] = c.cfg.Identity
+
+	conn, err := pgx.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return fmt.Errorf("connect to postgres: %w", err)
+	}
+
+	if c.cfg.Mode == ModeLeaseTable {
+		_, err = conn.Exec(ctx, fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+			name       text PRIMARY KEY,
+			holder     text NOT NULL,
+			expires_at timestamptz NOT NULL
+		)`, c.table()))
+		if err != nil {
+			conn.Close(ctx)
+			return fmt.Errorf("create lease table: %w", err)
+		}
+	}
+
+	c.connMu.Lock()
+	c.conn = conn
+	c.connMu.Unlock()
+
+	c.logger.Info("postgres session established",
+		slog.String("mode", c.cfg.Mode),
+		slog.Uint64("pid", uint64(conn.PgConn().PID())),
+	)
+	return nil
+}
+
+func (c *Coordinator) Connected() bool {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+	return c.conn != nil && !c.conn.IsClosed()
+}
+
+func (c *Coordinator) Acquire(ctx context.Context) error {
+	if !c.Connected() {
+		return coordination.ErrNotConnected
+	}
+
+	var start time.Time
+	for {
+		// The lease runs on the server from some moment after this.
+		start = time.Now()
+		acquired, err := c.tryAcquire(ctx)
+		if err != nil {
+			return err
+		}
+		if acquired {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.cfg.RetryPeriod):
+		}
+	}
+
+	epoch, err := c.nextEpoch(ctx)
+	if err != nil {
+		// Nobody releases what is held without leading, and a held advisory
+		// lock would block the election until the session ends.
+		c.connMu.Lock()
+		if c.conn != nil && !c.conn.IsClosed() {
+			unlockCtx, cancel := context.WithTimeout(context.Background(), c.cfg.RetryPeriod)
+			_ = c.unlock(unlockCtx)
+			cancel()
+		}
+		c.connMu.Unlock()
+		return err
+	}
+
+	lost := make(chan struct{})
+	keepCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	c.mu.Lock()
+	c.lost, c.cancel, c.done, c.epoch = lost, cancel, done, epoch
+	c.mu.Unlock()
+
+	go c.keepAlive(keepCtx, c.leaseDeadline(start), lost, done)
+
+	c.logger.Info("elected", slog.String("mode", c.cfg.Mode), slog.Int64("lock", c.lockID))
+	return nil
+}
+
+func (c *Coordinator) Lost() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+func (c *Coordinator) Epoch() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+func (c *Coordinator) Leader(ctx context.Context) (string, error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.conn == nil {
+		return "", coordination.ErrNotConnected
+	}
+
+	var err error
+	var holder string
+	switch c.cfg.Mode {
+	case ModeLeaseTable:
+		err = c.conn.QueryRow(ctx, fmt.Sprintf(`SELECT holder FROM %s WHERE name = $1 AND expires_at > now()`, c.table()),
+			c.cfg.ElectionPath).Scan(&holder)
+	default:
+		// A bigint advisory key is split into classid (high half) and objid
+		// (low half) in pg_locks.
+		err = c.conn.QueryRow(ctx, `SELECT a.application_name
+			FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+			WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+				AND l.classid = (($1::bigint >> 32) & 4294967295)::oid
+				AND l.objid = ($1::bigint & 4294967295)::oid`, c.lockID).Scan(&holder)
+	}
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get leader: %w", err)
+	}
+	return holder, nil
+}
+
+func (c *Coordinator) Release(ctx context.Context) error {
+	c.mu.Lock()
+	cancel, done := c.cancel, c.done
+	c.cancel, c.done = nil, nil
+	c.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-done
+
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.conn == nil || c.conn.IsClosed() {
+		return nil
+	}
+
+	if err := c.unlock(ctx); err != nil {
+		return fmt.Errorf("release leadership: %w", err)
+	}
+	return nil
+}
+
+// unlock must be called with connMu held.
+func (c *Coordinator) unlock(ctx context.Context) error {
+	if c.cfg.Mode == ModeLeaseTable {
+		_, err := c.conn.Exec(ctx, fmt.Sprintf(`DELETE FROM %s WHERE name = $1 AND holder = $2`, c.table()),
+			c.cfg.ElectionPath, c.cfg.Identity)
+		return err
+	}
+
+	// Advisory locks stack within a session, every lock taken needs an unlock.
+	for {
+		var unlocked bool
+		err := c.conn.QueryRow(ctx, `SELECT pg_advisory_unlock($1)`, c.lockID).Scan(&unlocked)
+		if err != nil || !unlocked {
+			return err
+		}
+	}
+}
+
+// Racing is true in both modes, candidates retry the lock or the lease row
+// and the first one after a release takes it.
+func (c *Coordinator) Racing() bool {
+	return true
+}
+
+func (c *Coordinator) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.RetryPeriod)
+	defer cancel()
+
+	err := c.Release(ctx)
+
+	c.connMu.Lock()
+	conn := c.conn
+	c.conn = nil
+	c.connMu.Unlock()
+
+	if conn != nil {
+		// Closing the session drops its advisory locks as well.
+		return errors.Join(err, conn.Close(ctx))
+	}
+	return err
+}
+
+func (c *Coordinator) tryAcquire(ctx context.Context) (bool, error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.conn == nil {
+		return false, coordination.ErrNotConnected
+	}
+
+	var acquired bool
+	switch c.cfg.Mode {
+	case ModeLeaseTable:
+		var holder string
+		err := c.conn.QueryRow(ctx, fmt.Sprintf(`INSERT INTO %[1]s (name, holder, expires_at)
+			VALUES ($1, $2, now() + $3 * interval '1 millisecond')
+			ON CONFLICT (name) DO UPDATE SET holder = EXCLUDED.holder, expires_at = EXCLUDED.expires_at
+			WHERE %[1]s.expires_at < now() OR %[1]s.holder = EXCLUDED.holder
+			RETURNING holder`, c.table()),
+			c.cfg.ElectionPath, c.cfg.Identity, c.cfg.LeaseTTL.Milliseconds(),
+		).Scan(&holder)
+		if errors.Is(err, pgx.ErrNoRows) {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("acquire lease: %w", err)
+		}
+		acquired = true
+	default:
+		err := c.conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, c.lockID).Scan(&acquired)
+		if err != nil {
+			return false, fmt.Errorf("try advisory lock: %w", err)
+		}
+	}
+	return acquired, nil
+}
+
+// nextEpoch takes a fresh transaction id, which grows across the whole
+// cluster and so orders our leadership after all previous ones.
+func (c *Coordinator) nextEpoch(ctx context.Context) (int64, error) {
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.conn == nil {
+		return 0, coordination.ErrNotConnected
+	}
+
+	var epoch int64
+	err := c.conn.QueryRow(ctx, `SELECT txid_current()`).Scan(&epoch)
+	if err != nil {
+		return 0, fmt.Errorf("take epoch: %w", err)
+	}
+	return epoch, nil
+}
+
+// leaseSafetyDivisor: the local deadline of a lease is LeaseTTL/leaseSafetyDivisor
+// ahead of its expiry on the server, to make up for the drift of our clock.
+const leaseSafetyDivisor = 10
+
+// leaseDeadline is when we must consider a lease renewed at start expired.
+func (c *Coordinator) leaseDeadline(start time.Time) time.Time {
+	return start.Add(c.cfg.LeaseTTL - c.cfg.LeaseTTL/leaseSafetyDivisor)
+}
+
+// keepAlive checks the session, and renews the lease row in lease mode, every
+// retry period. Leadership is lost once a check fails or, for leases, at the
+// deadline of the last successful renewal.
+func (c *Coordinator) keepAlive(ctx context.Context, deadline time.Time, lost, done chan struct{}) {
+	defer close(done)
+	defer close(lost)
+
+	ticker := time.NewTicker(c.cfg.RetryPeriod)
+	defer ticker.Stop()
+
+	var expired <-chan time.Time
+	if c.cfg.Mode == ModeLeaseTable {
+		expired = time.After(time.Until(deadline))
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-expired:
+			c.logger.Warn("lease expired before it was renewed")
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		err := c.renew(ctx, deadline)
+		if err == nil {
+			deadline = c.leaseDeadline(start)
+			if c.cfg.Mode == ModeLeaseTable {
+				expired = time.After(time.Until(deadline))
+			}
+			continue
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		c.logger.Warn("leadership check failed", slog.String("error", err.Error()))
+		if c.cfg.Mode != ModeLeaseTable || !time.Now().Before(deadline) || errors.Is(err, errLeaseTaken) {
+			return
+		}
+	}
+}
+
+var errLeaseTaken = errors.New("lease is held by another replica")
+
+// renew gives up at the deadline, a later renewal would not save the lease.
+func (c *Coordinator) renew(ctx context.Context, deadline time.Time) error {
+	ctx, cancel := context.WithTimeout(ctx, c.cfg.RetryPeriod)
+	defer cancel()
+	if c.cfg.Mode == ModeLeaseTable {
+		var cancelDeadline context.CancelFunc
+		ctx, cancelDeadline = context.WithDeadline(ctx, deadline)
+		defer cancelDeadline()
+	}
+
+	c.connMu.Lock()
+	defer c.connMu.Unlock()
+
+	if c.conn == nil || c.conn.IsClosed() {
+		return coordination.ErrNotConnected
+	}
+
+	if c.cfg.Mode != ModeLeaseTable {
+		return c.conn.Ping(ctx)
+	}
+
+	tag, err := c.conn.Exec(ctx, fmt.Sprintf(`UPDATE %s SET expires_at = now() + $3 * interval '1 millisecond'
+		WHERE name = $1 AND holder = $2`, c.table()),
+		c.cfg.ElectionPath, c.cfg.Identity, c.cfg.LeaseTTL.Milliseconds(),
+	)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errLeaseTaken
+	}
+	return nil
+}
+
+func (c *Coordinator) table() string {
+	return pgx.Identifier{c.cfg.Table}.Sanitize()
+}
+
+// lockID maps the election path onto the bigint key space of advisory locks.
+func lockID(electionPath string) int64 {
+	h := fnv.New64a()
+	_, _ = h.Write([]byte(electionPath))
+	return int64(h.Sum64())
+}
@@ -0,0 +1,42 @@
+package raft
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// NewInmemCluster returns n coordinators whose servers talk over connected
+// in-memory transports, so a multi-node election runs inside one process.
+// The servers are node-i and the replicas behind them replica-i.
+func NewInmemCluster(n int, sessionTimeout time.Duration, logger *slog.Logger) []*Coordinator {
+	peers := make([]string, 0, n)
+	transports := make([]*raft.InmemTransport, 0, n)
+	for i := 0; i < n; i++ {
+		addr, transport := raft.NewInmemTransport(raft.ServerAddress(fmt.Sprintf("node-%d", i)))
+		peers = append(peers, string(addr))
+		transports = append(transports, transport)
+	}
+
+	for _, from := range transports {
+		for _, to := range transports {
+			if from != to {
+				from.Connect(to.LocalAddr(), to)
+			}
+		}
+	}
+
+	coordinators := make([]*Coordinator, 0, n)
+	for i, transport := range transports {
+		coordinators = append(coordinators, New(Config{
+			Bind:           peers[i],
+			Identity:       fmt.Sprintf("replica-%d", i),
+			Peers:          peers,
+			SessionTimeout: sessionTimeout,
+			Transport:      transport,
+		}, logger))
+	}
+	return coordinators
+}
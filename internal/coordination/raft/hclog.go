@@ -0,0 +1,138 @@
+package raft
+
+import (
+	"context"
+	"io"
+	"log"
+	"log/slog"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+var _ hclog.Logger = &hclogAdapter{}
+
+// hclogAdapter writes the logs of the Raft library to a slog logger, the
+// library only takes an hclog one.
+type hclogAdapter struct {
+	logger  *slog.Logger
+	name    string
+	implied []interface{}
+	level   hclog.Level
+}
+
+// newHCLogger logs the messages of level and above to logger.
+func newHCLogger(logger *slog.Logger, level hclog.Level) *hclogAdapter {
+	return &hclogAdapter{logger: logger, level: level}
+}
+
+func (l *hclogAdapter) Log(level hclog.Level, msg string, args ...interface{}) {
+	if level == hclog.Off || level < l.level {
+		return
+	}
+	if l.name != "" {
+		msg = l.name + ": " + msg
+	}
+	l.logger.Log(context.Background(), slogLevel(level), msg, args...)
+}
+
+func (l *hclogAdapter) Trace(msg string, args ...interface{}) {
+	l.Log(hclog.Trace, msg, args...)
+}
+
+func (l *hclogAdapter) Debug(msg string, args ...interface{}) {
+	l.Log(hclog.Debug, msg, args...)
+}
+
+func (l *hclogAdapter) Info(msg string, args ...interface{}) {
+	l.Log(hclog.Info, msg, args...)
+}
+
+func (l *hclogAdapter) Warn(msg string, args ...interface{}) {
+	l.Log(hclog.Warn, msg, args...)
+}
+
+func (l *hclogAdapter) Error(msg string, args ...interface{}) {
+	l.Log(hclog.Error, msg, args...)
+}
+
+func (l *hclogAdapter) IsTrace() bool {
+	return l.enabled(hclog.Trace)
+}
+
+func (l *hclogAdapter) IsDebug() bool {
+	return l.enabled(hclog.Debug)
+}
+
+func (l *hclogAdapter) IsInfo() bool {
+	return l.enabled(hclog.Info)
+}
+
+func (l *hclogAdapter) IsWarn() bool {
+	return l.enabled(hclog.Warn)
+}
+
+func (l *hclogAdapter) IsError() bool {
+	return l.enabled(hclog.Error)
+}
+
+func (l *hclogAdapter) ImpliedArgs() []interface{} {
+	return l.implied
+}
+
+func (l *hclogAdapter) With(args ...interface{}) hclog.Logger {
+	with := *l
+	with.logger = l.logger.With(args...)
+	with.implied = append(append([]interface{}{}, l.implied...), args...)
+	return &with
+}
+
+func (l *hclogAdapter) Name() string {
+	return l.name
+}
+
+func (l *hclogAdapter) Named(name string) hclog.Logger {
+	if l.name != "" {
+		name = l.name + "." + name
+	}
+	return l.ResetNamed(name)
+}
+
+func (l *hclogAdapter) ResetNamed(name string) hclog.Logger {
+	named := *l
+	named.name = name
+	return &named
+}
+
+// SetLevel is a no-op, the level is fixed when the adapter is made.
+func (l *hclogAdapter) SetLevel(hclog.Level) {}
+
+func (l *hclogAdapter) GetLevel() hclog.Level {
+	return l.level
+}
+
+func (l *hclogAdapter) StandardLogger(*hclog.StandardLoggerOptions) *log.Logger {
+	return slog.NewLogLogger(l.logger.Handler(), slog.LevelInfo)
+}
+
+func (l *hclogAdapter) StandardWriter(opts *hclog.StandardLoggerOptions) io.Writer {
+	return l.StandardLogger(opts).Writer()
+}
+
+func (l *hclogAdapter) enabled(level hclog.Level) bool {
+	return level != hclog.Off && level >= l.level && l.logger.Enabled(context.Background(), slogLevel(level))
+}
+
+func slogLevel(level hclog.Level) slog.Level {
+	switch level {
+	case hclog.Trace:
+		return slog.LevelDebug - 4
+	case hclog.Debug:
+		return slog.LevelDebug
+	case hclog.Info:
+		return slog.LevelInfo
+	case hclog.Warn:
+		return slog.LevelWarn
+	default:
+		return slog.LevelError
+	}
+}
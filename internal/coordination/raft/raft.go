@@ -0,0 +1,398 @@
+package raft
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/hashicorp/go-hclog"
+	"github.com/hashicorp/raft"
+)
+
+var _ coordination.Coordinator = &Coordinator{}
+
+// checkInterval bounds the time a missed observation can delay noticing a
+// leadership change.
+const checkInterval = time.Second
+
+type Config struct {
+	// Bind is the address the transport listens on, it doubles as server ID.
+	Bind string
+	// Identity names the replica in Leader of every voter, defaults to Bind.
+	Identity string
+	// Peers lists the addresses of all voters, including Bind.
+	Peers          []string
+	SessionTimeout time.Duration
+	// Transport is used instead of a TCP transport on Bind when set. It is
+	// owned by the caller and never closed.
+	Transport raft.Transport
+}
+
+func New(cfg Config, logger *slog.Logger) *Coordinator {
+	if cfg.Identity == "" {
+		cfg.Identity = cfg.Bind
+	}
+
+	lost := make(chan struct{})
+	close(lost)
+
+	return &Coordinator{
+		cfg:    cfg,
+		logger: logger.With("subsystem", "RaftCoordinator", "server", cfg.Bind),
+		fsm:    &fsm{identities: make(map[raft.ServerID]string)},
+		lost:   lost,
+	}
+}
+
+// Coordinator makes the replicas form their own Raft group, so no external
+// coordinator is needed. The Raft leader is the election leader.
+type Coordinator struct {
+	cfg    Config
+	logger *slog.Logger
+	fsm    *fsm
+
+	mu        sync.Mutex
+	raft      *raft.Raft
+	transport raft.Transport
+	store     *raft.InmemStore
+	// released is set while the server is stopped by Release, Acquire
+	// starts it again.
+	released bool
+	epoch    int64
+	lost     chan struct{}
+}
+
+// Connect starts the local Raft server and bootstraps the group from the
+// peer list. A running server is kept as is, Raft heals itself.
+func (c *Coordinator) Connect(context.Context) error {
+	if c.Connected() {
+		return nil
+	}
+	return c.start()
+}
+
+// start runs a Raft server on the store of the previous one, if any, so a
+// restarted server keeps its log and term like a restarted process would.
+func (c *Coordinator) start() error {
+	conf := raft.DefaultConfig()
+	conf.LocalID = raft.ServerID(c.cfg.Bind)
+	conf.HeartbeatTimeout = c.cfg.SessionTimeout / 2
+	conf.ElectionTimeout = c.cfg.SessionTimeout / 2
+	conf.LeaderLeaseTimeout = c.cfg.SessionTimeout / 4
+	conf.Logger = newHCLogger(c.logger, hclog.Warn).Named("raft")
+
+	// Shutting a server down closes its transport, ours is made anew for
+	// every server and the one of the caller is hidden from it.
+	var transport raft.Transport = callerTransport{c.cfg.Transport}
+	if c.cfg.Transport == nil {
+		tcp, err := raft.NewTCPTransport(c.cfg.Bind, nil, 3, c.cfg.SessionTimeout, io.Discard)
+		if err != nil {
+			return fmt.Errorf("create raft transport on %s: %w", c.cfg.Bind, err)
+		}
+		transport = tcp
+	}
+
+	c.mu.Lock()
+	if c.store == nil {
+		c.store = raft.NewInmemStore()
+	}
+	store := c.store
+	c.mu.Unlock()
+
+	r, err := raft.NewRaft(conf, c.fsm, store, store, raft.NewDiscardSnapshotStore(), transport)
+	if err != nil {
+		closeTransport(transport)
+		return fmt.Errorf("create raft server: %w", err)
+	}
+
+	servers := make([]raft.Server, 0, len(c.cfg.Peers))
+	for _, peer := range c.cfg.Peers {
+		servers = append(servers, raft.Server{
+			ID:      raft.ServerID(peer),
+			Address: raft.ServerAddress(peer),
+		})
+	}
+
+	err = r.BootstrapCluster(raft.Configuration{Servers: servers}).Error()
+	if err != nil && !errors.Is(err, raft.ErrCantBootstrap) {
+		_ = r.Shutdown().Error()
+		return fmt.Errorf("bootstrap raft group: %w", err)
+	}
+
+	c.mu.Lock()
+	c.raft, c.transport, c.released = r, transport, false
+	c.mu.Unlock()
+
+	c.logger.Info("raft server started", slog.Int("peers", len(servers)))
+	return nil
+}
+
+// Connected is also true while the server is stopped by Release.
+func (c *Coordinator) Connected() bool {
+	c.mu.Lock()
+	r, released := c.raft, c.released
+	c.mu.Unlock()
+	return released || r != nil && r.State() != raft.Shutdown
+}
+
+func (c *Coordinator) Acquire(ctx context.Context) error {
+	c.mu.Lock()
+	released := c.released
+	c.mu.Unlock()
+
+	if released {
+		err := c.start()
+		if err != nil {
+			return err
+		}
+	}
+
+	r := c.server()
+	if r == nil {
+		return coordination.ErrNotConnected
+	}
+
+	for {
+		err := c.waitState(ctx, r, func(state raft.RaftState) bool {
+			return state == raft.Leader || state == raft.Shutdown
+		})
+		if err != nil {
+			return err
+		}
+		if r.State() == raft.Shutdown {
+			return coordination.ErrNotConnected
+		}
+
+		// Server IDs are addresses, the entry tells every voter who the
+		// replica behind this one is.
+		err = c.announce(r)
+		if err == nil {
+			break
+		}
+		c.logger.Warn("can not announce identity", slog.String("error", err.Error()))
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	// Raft terms only grow and a term has at most one leader.
+	epoch, err := strconv.ParseInt(r.Stats()["term"], 10, 64)
+	if err != nil {
+		return fmt.Errorf("parse raft term: %w", err)
+	}
+
+	lost := make(chan struct{})
+	c.mu.Lock()
+	c.lost, c.epoch = lost, epoch
+	c.mu.Unlock()
+
+	go func() {
+		defer close(lost)
+		_ = c.waitState(context.Background(), r, func(state raft.RaftState) bool {
+			return state != raft.Leader
+		})
+		c.logger.Warn("raft leadership lost", slog.String("state", r.State().String()))
+	}()
+
+	c.logger.Info("elected", slog.Int64("term", epoch))
+	return nil
+}
+
+func (c *Coordinator) Lost() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+func (c *Coordinator) Epoch() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+func (c *Coordinator) Leader(context.Context) (string, error) {
+	r := c.server()
+	if r == nil {
+		return "", coordination.ErrNotConnected
+	}
+
+	_, id := r.LeaderWithID()
+	if id == "" {
+		return "", nil
+	}
+	return c.fsm.identity(id), nil
+}
+
+// Release hands leadership over to another voter, if there is one, and stops
+// the local server: a running follower would vote and could win the next
+// election while this replica does not campaign. Acquire starts it again.
+func (c *Coordinator) Release(context.Context) error {
+	c.mu.Lock()
+	r, transport := c.raft, c.transport
+	c.raft, c.transport = nil, nil
+	c.released = c.released || r != nil
+	c.mu.Unlock()
+
+	if r == nil {
+		return nil
+	}
+
+	if r.State() == raft.Leader && c.otherVoters(r) {
+		err := r.LeadershipTransfer().Error()
+		if err != nil {
+			// Stopping the server hands leadership over as well, only later.
+			c.logger.Warn("can not transfer raft leadership", slog.String("error", err.Error()))
+		}
+	}
+
+	err := r.Shutdown().Error()
+	closeTransport(transport)
+	if err != nil {
+		return fmt.Errorf("stop raft server: %w", err)
+	}
+	return nil
+}
+
+func (c *Coordinator) Close() error {
+	c.mu.Lock()
+	r, transport := c.raft, c.transport
+	c.raft, c.transport, c.released = nil, nil, false
+	c.mu.Unlock()
+
+	if r == nil {
+		return nil
+	}
+	err := r.Shutdown().Error()
+	closeTransport(transport)
+	return err
+}
+
+// otherVoters reports whether anyone but the local server can lead the group.
+func (c *Coordinator) otherVoters(r *raft.Raft) bool {
+	future := r.GetConfiguration()
+	if future.Error() != nil {
+		return false
+	}
+	for _, server := range future.Configuration().Servers {
+		if server.ID != raft.ServerID(c.cfg.Bind) && server.Suffrage == raft.Voter {
+			return true
+		}
+	}
+	return false
+}
+
+// announce commits the identity of the local server to the group log.
+func (c *Coordinator) announce(r *raft.Raft) error {
+	data, err := json.Marshal(announcement{ID: raft.ServerID(c.cfg.Bind), Identity: c.cfg.Identity})
+	if err != nil {
+		return fmt.Errorf("encode announcement: %w", err)
+	}
+	return r.Apply(data, c.cfg.SessionTimeout).Error()
+}
+
+func (c *Coordinator) server() *raft.Raft {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.raft
+}
+
+// waitState blocks until done accepts the state of r. Observations only wake
+// the loop up, the state itself is always read from r.
+func (c *Coordinator) waitState(ctx context.Context, r *raft.Raft, done func(raft.RaftState) bool) error {
+	changes := make(chan raft.Observation, 1)
+	observer := raft.NewObserver(changes, false, func(o *raft.Observation) bool {
+		_, ok := o.Data.(raft.RaftState)
+		return ok
+	})
+	r.RegisterObserver(observer)
+	defer r.DeregisterObserver(observer)
+
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+
+	for !done(r.State()) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changes:
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// callerTransport hides the Close of a transport given in Config, so that
+// stopping a server does not disconnect it.
+type callerTransport struct {
+	raft.Transport
+}
+
+// closeTransport closes the transports made by the coordinator, shutting a
+// server down does too, but not when NewRaft failed.
+func closeTransport(transport raft.Transport) {
+	if closer, ok := transport.(raft.WithClose); ok {
+		_ = closer.Close()
+	}
+}
+
+// announcement is the log entry a new leader commits with its identity.
+type announcement struct {
+	ID       raft.ServerID `json:"id"`
+	Identity string        `json:"identity"`
+}
+
+// fsm only learns the identities of the servers from their announcements,
+// the group exists to elect a leader. Every leader announces itself anew and
+// the fsm outlives restarts of the server like the store does, so nothing is
+// kept in snapshots.
+type fsm struct {
+	mu         sync.Mutex
+	identities map[raft.ServerID]string
+}
+
+func (f *fsm) Apply(log *raft.Log) interface{} {
+	var a announcement
+	if err := json.Unmarshal(log.Data, &a); err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	f.identities[a.ID] = a.Identity
+	f.mu.Unlock()
+	return nil
+}
+
+// identity returns the identity announced by the server id, or id itself if
+// it has not announced one yet.
+func (f *fsm) identity(id raft.ServerID) string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if identity, ok := f.identities[id]; ok {
+		return identity
+	}
+	return string(id)
+}
+
+func (*fsm) Snapshot() (raft.FSMSnapshot, error) {
+	return snapshot{}, nil
+}
+
+func (*fsm) Restore(rc io.ReadCloser) error {
+	return rc.Close()
+}
+
+type snapshot struct{}
+
+func (snapshot) Persist(sink raft.SnapshotSink) error {
+	return sink.Close()
+}
+
+func (snapshot) Release() {}
@@ -0,0 +1,197 @@
+package raft
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-hclog"
+)
+
+const testSessionTimeout = 500 * time.Millisecond
+
+func testLogger() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// elect connects every coordinator and returns the first one to acquire.
+func elect(t *testing.T, ctx context.Context, coordinators []*Coordinator) *Coordinator {
+	t.Helper()
+
+	won := make(chan *Coordinator, len(coordinators))
+	acquireCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	for _, c := range coordinators {
+		if err := c.Connect(ctx); err != nil {
+			t.Fatalf("connect %s: %v", c.cfg.Bind, err)
+		}
+		go func() {
+			if c.Acquire(acquireCtx) == nil {
+				won <- c
+			}
+		}()
+	}
+
+	select {
+	case c := <-won:
+		return c
+	case <-ctx.Done():
+		t.Fatal("nobody was elected")
+		return nil
+	}
+}
+
+func closeAll(t *testing.T, coordinators []*Coordinator) {
+	t.Cleanup(func() {
+		for _, c := range coordinators {
+			_ = c.Close()
+		}
+	})
+}
+
+func TestReleaseHandsLeadershipOver(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	coordinators := NewInmemCluster(3, testSessionTimeout, testLogger())
+	closeAll(t, coordinators)
+
+	leader := elect(t, ctx, coordinators)
+	term := leader.Epoch()
+
+	if err := leader.Release(ctx); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	select {
+	case <-leader.Lost():
+	case <-ctx.Done():
+		t.Fatal("leadership was not lost on release")
+	}
+	if !leader.Connected() {
+		t.Fatal("a released coordinator must stay connected")
+	}
+
+	var rest []*Coordinator
+	for _, c := range coordinators {
+		if c != leader {
+			rest = append(rest, c)
+		}
+	}
+	next := elect(t, ctx, rest)
+	if next.Epoch() <= term {
+		t.Fatalf("epoch %d after release, want more than %d", next.Epoch(), term)
+	}
+
+	// The released server neither votes nor campaigns until Acquire.
+	if id, _ := leader.Leader(ctx); id != "" {
+		t.Fatalf("released server reports leader %q", id)
+	}
+
+	// Acquire starts the server again, it rejoins as a follower.
+	acquireCtx, cancelAcquire := context.WithTimeout(ctx, 4*testSessionTimeout)
+	defer cancelAcquire()
+	if err := leader.Acquire(acquireCtx); err == nil {
+		t.Fatal("the rejoined server took leadership from a healthy leader")
+	}
+	if id, err := leader.Leader(ctx); err != nil || id != next.cfg.Identity {
+		t.Fatalf("rejoined server sees leader %q, %v, want %q", id, err, next.cfg.Identity)
+	}
+}
+
+func TestReleaseSingleNode(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	coordinators := NewInmemCluster(1, testSessionTimeout, testLogger())
+	closeAll(t, coordinators)
+
+	c := elect(t, ctx, coordinators)
+	term := c.Epoch()
+
+	if err := c.Release(ctx); err != nil {
+		t.Fatalf("release of the only voter: %v", err)
+	}
+	if err := c.Acquire(ctx); err != nil {
+		t.Fatalf("acquire after release: %v", err)
+	}
+	if c.Epoch() <= term {
+		t.Fatalf("epoch %d after re-election, want more than %d", c.Epoch(), term)
+	}
+}
+
+func TestCloseFreesTCPAddress(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	_ = listener.Close()
+
+	c := New(Config{Bind: addr, Peers: []string{addr}, SessionTimeout: testSessionTimeout}, testLogger())
+	defer c.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := c.Connect(ctx); err != nil {
+			t.Fatalf("connect %d: %v", i, err)
+		}
+		if err := c.Acquire(ctx); err != nil {
+			t.Fatalf("acquire %d: %v", i, err)
+		}
+		if err := c.Close(); err != nil {
+			t.Fatalf("close %d: %v", i, err)
+		}
+	}
+}
+
+func TestLeaderNamesIdentity(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	coordinators := NewInmemCluster(3, testSessionTimeout, testLogger())
+	closeAll(t, coordinators)
+
+	leader := elect(t, ctx, coordinators)
+	for _, c := range coordinators {
+		for {
+			id, err := c.Leader(ctx)
+			if err != nil {
+				t.Fatalf("leader seen by %s: %v", c.cfg.Identity, err)
+			}
+			if id == leader.cfg.Identity {
+				break
+			}
+			select {
+			case <-ctx.Done():
+				t.Fatalf("%s sees leader %q, want %q", c.cfg.Identity, id, leader.cfg.Identity)
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+}
+
+func TestRaftLogsGoToLogger(t *testing.T) {
+	var out bytes.Buffer
+	logger := newHCLogger(slog.New(slog.NewTextHandler(&out, nil)), hclog.Warn).Named("raft").With("peer", "node-1")
+
+	logger.Info("skipped")
+	logger.Warn("heartbeat failed", "error", "timeout")
+
+	got := out.String()
+	if strings.Contains(got, "skipped") {
+		t.Fatalf("message below the level logged: %s", got)
+	}
+	for _, want := range []string{"level=WARN", `msg="raft: heartbeat failed"`, "peer=node-1", "error=timeout"} {
+		if !strings.Contains(got, want) {
+			t.Fatalf("log %q lacks %s", got, want)
+		}
+	}
+}
@@ -0,0 +1,299 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/redis/go-redis/v9"
+)
+
+var (
+	_ coordination.Coordinator = &Coordinator{}
+	_ coordination.Racing      = &Coordinator{}
+)
+
+// renewFraction is the part of the TTL after which the lease is renewed and a
+// follower retries to acquire it.
+const renewFraction = 3
+
+// leaseSafetyDivisor: the local deadline of a lease is TTL/leaseSafetyDivisor
+// ahead of its expiry in Redis, to make up for the drift of our clock.
+const leaseSafetyDivisor = 10
+
+var (
+	// acquireScript sets the lease and hands out the next fencing token in
+	// one step, so tokens only grow with successful acquisitions.
+	acquireScript = redis.NewScript(`
+if redis.call("SET", KEYS[1], ARGV[1], "NX", "PX", ARGV[2]) then
+	return redis.call("INCR", KEYS[2])
+end
+return 0`)
+
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+var errLeaseTaken = errors.New("lease is held by another replica")
+
+type Config struct {
+	Addr         string
+	ElectionPath string
+	Identity     string
+	TTL          time.Duration
+}
+
+func New(cfg Config, logger *slog.Logger) *Coordinator {
+	lost := make(chan struct{})
+	close(lost)
+
+	return &Coordinator{
+		cfg:    cfg,
+		logger: logger.With("subsystem", "RedisCoordinator"),
+		lost:   lost,
+	}
+}
+
+// Coordinator elects the leader with a Redis key holding a TTL lease. Every
+// acquisition gets a fencing token from a separate INCR counter.
+type Coordinator struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu     sync.Mutex
+	client *redis.Client
+	value  string
+	token  int64
+	lost   chan struct{}
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func (c *Coordinator) Connect(ctx context.Context) error {
+	c.Close()
+
+	// Renewals are bounded by the lease deadline through their context.
+	client := redis.NewClient(&redis.Options{Addr: c.cfg.Addr, ContextTimeoutEnabled: true})
+	err := client.Ping(ctx).Err()
+	if err != nil {
+		_ = client.Close()
+		return fmt.Errorf("connect to redis: %w", err)
+	}
+
+	c.mu.Lock()
+	c.client = client
+	c.mu.Unlock()
+
+	c.logger.Info("redis client ready", slog.String("addr", c.cfg.Addr))
+	return nil
+}
+
+func (c *Coordinator) Connected() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.client != nil
+}
+
+func (c *Coordinator) Acquire(ctx context.Context) error {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+
+	if client == nil {
+		return coordination.ErrNotConnected
+	}
+
+	value := fmt.Sprintf("%s:%d", c.cfg.Identity, time.Now().UnixNano())
+	var start time.Time
+	for {
+		start = time.Now()
+		token, err := acquireScript.Run(ctx, client, []string{c.leaseKey(), c.fencingKey()},
+			value, c.cfg.TTL.Milliseconds()).Int64()
+		if err != nil {
+			return fmt.Errorf("acquire lease: %w", err)
+		}
+		if token > 0 {
+			c.mu.Lock()
+			c.value, c.token = value, token
+			c.mu.Unlock()
+
+			c.logger.Info("elected", slog.String("key", c.leaseKey()), slog.Int64("token", token))
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(c.cfg.TTL / renewFraction):
+		}
+	}
+
+	lost := make(chan struct{})
+	renewCtx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	c.mu.Lock()
+	c.lost, c.cancel, c.done = lost, cancel, done
+	c.mu.Unlock()
+
+	go c.renew(renewCtx, client, value, c.leaseDeadline(start), lost, done)
+
+	return nil
+}
+
+// Epoch returns the fencing token handed out with the current lease.
+func (c *Coordinator) Epoch() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token
+}
+
+func (c *Coordinator) Leader(ctx context.Context) (string, error) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+
+	if client == nil {
+		return "", coordination.ErrNotConnected
+	}
+
+	value, err := client.Get(ctx, c.leaseKey()).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get lease: %w", err)
+	}
+
+	// The lease value is the identity with the acquisition time appended.
+	if idx := strings.LastIndexByte(value, ':'); idx >= 0 {
+		value = value[:idx]
+	}
+	return value, nil
+}
+
+func (c *Coordinator) Lost() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+func (c *Coordinator) Release(ctx context.Context) error {
+	c.mu.Lock()
+	client, value, cancel, done := c.client, c.value, c.cancel, c.done
+	c.value, c.cancel, c.done = "", nil, nil
+	c.mu.Unlock()
+
+	if cancel == nil {
+		return nil
+	}
+	cancel()
+	<-done
+
+	err := releaseScript.Run(ctx, client, []string{c.leaseKey()}, value).Err()
+	if err != nil {
+		return fmt.Errorf("release lease: %w", err)
+	}
+	return nil
+}
+
+// Racing is true, candidates retry SET NX and the first one after a release
+// takes the lease.
+func (c *Coordinator) Racing() bool {
+	return true
+}
+
+func (c *Coordinator) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.cfg.TTL/renewFraction)
+	defer cancel()
+
+	err := c.Release(ctx)
+
+	c.mu.Lock()
+	client := c.client
+	c.client = nil
+	c.mu.Unlock()
+
+	if client != nil {
+		return errors.Join(err, client.Close())
+	}
+	return err
+}
+
+// renew extends the lease every fraction of the TTL and closes lost as soon
+// as a renewal fails, since the lease may already be taken by then. A
+// renewal that does not return before the lease deadline counts as failed.
+func (c *Coordinator) renew(ctx context.Context, client *redis.Client, value string, deadline time.Time, lost, done chan struct{}) {
+	defer close(done)
+	defer close(lost)
+
+	ticker := time.NewTicker(c.cfg.TTL / renewFraction)
+	defer ticker.Stop()
+
+	expired := time.After(time.Until(deadline))
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-expired:
+			c.logger.Warn("lease expired before it was renewed")
+			return
+		case <-ticker.C:
+		}
+
+		start := time.Now()
+		err := c.renewOnce(ctx, client, value, deadline)
+		if err != nil {
+			if ctx.Err() == nil {
+				c.logger.Warn("lease renewal failed", slog.String("error", err.Error()))
+			}
+			return
+		}
+		deadline = c.leaseDeadline(start)
+		expired = time.After(time.Until(deadline))
+	}
+}
+
+// renewOnce gives up at the deadline, a later renewal would not save the
+// lease.
+func (c *Coordinator) renewOnce(ctx context.Context, client *redis.Client, value string, deadline time.Time) error {
+	ctx, cancel := context.WithDeadline(ctx, deadline)
+	defer cancel()
+
+	renewed, err := renewScript.Run(ctx, client, []string{c.leaseKey()}, value, c.cfg.TTL.Milliseconds()).Int64()
+	if err != nil {
+		return err
+	}
+	if renewed == 0 {
+		return errLeaseTaken
+	}
+	return nil
+}
+
+// leaseDeadline is when we must consider a lease set or renewed at start
+// expired.
+func (c *Coordinator) leaseDeadline(start time.Time) time.Time {
+	return start.Add(c.cfg.TTL - c.cfg.TTL/leaseSafetyDivisor)
+}
+
+func (c *Coordinator) leaseKey() string {
+	return c.cfg.ElectionPath
+}
+
+func (c *Coordinator) fencingKey() string {
+	return c.cfg.ElectionPath + ":fencing"
+}
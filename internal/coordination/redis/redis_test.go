@@ -0,0 +1,257 @@
+package redis
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/coordinationtest"
+)
+
+const testTTL = 300 * time.Millisecond
+
+func newTestCoordinator(t *testing.T, server *miniredis.Miniredis, identity string) *Coordinator {
+	t.Helper()
+	return newTestCoordinatorAt(t, server.Addr(), "/election", identity)
+}
+
+func newTestCoordinatorAt(t *testing.T, addr, electionPath, identity string) *Coordinator {
+	t.Helper()
+
+	c := New(Config{
+		Addr:         addr,
+		ElectionPath: electionPath,
+		Identity:     identity,
+		TTL:          testTTL,
+	}, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { _ = c.Close() })
+
+	if err := c.Connect(context.Background()); err != nil {
+		t.Fatalf("connect %s: %v", identity, err)
+	}
+	return c
+}
+
+func acquire(t *testing.T, c *Coordinator) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Acquire(ctx); err != nil {
+		t.Fatalf("acquire %s: %v", c.cfg.Identity, err)
+	}
+}
+
+func fencingToken(t *testing.T, server *miniredis.Miniredis, c *Coordinator) int64 {
+	t.Helper()
+
+	value, err := server.Get(c.fencingKey())
+	if err != nil {
+		t.Fatalf("get fencing token: %v", err)
+	}
+	token, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		t.Fatalf("parse fencing token %q: %v", value, err)
+	}
+	return token
+}
+
+func TestConformance(t *testing.T) {
+	server := miniredis.RunT(t)
+	coordinationtest.Run(t, func(t *testing.T, election, identity string) coordination.Coordinator {
+		return newTestCoordinatorAt(t, server.Addr(), "/"+election, identity)
+	})
+}
+
+func TestRenewalsKeepLeaseAndToken(t *testing.T) {
+	server := miniredis.RunT(t)
+	first := newTestCoordinator(t, server, "first")
+	second := newTestCoordinator(t, server, "second")
+
+	acquire(t, first)
+
+	// Past the TTL the renewals still hold the lease.
+	ctx, cancel := context.WithTimeout(context.Background(), 2*testTTL)
+	defer cancel()
+	if err := second.Acquire(ctx); err == nil {
+		t.Fatal("second was elected while first leads")
+	}
+	if fencingToken(t, server, first) != first.Epoch() {
+		t.Fatal("fencing token grew without an election")
+	}
+}
+
+func TestLostWhenLeaseTaken(t *testing.T) {
+	server := miniredis.RunT(t)
+	first := newTestCoordinator(t, server, "first")
+
+	acquire(t, first)
+
+	// Another holder shows up, as after the lease expired during a pause.
+	server.Set(first.leaseKey(), "intruder:1")
+
+	select {
+	case <-first.Lost():
+	case <-time.After(2 * testTTL):
+		t.Fatal("leadership kept after the lease was taken")
+	}
+
+	// Release leaves the other holder's lease alone.
+	if err := first.Release(context.Background()); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if value, _ := server.Get(first.leaseKey()); value != "intruder:1" {
+		t.Fatalf("lease is %q after release, want intruder:1", value)
+	}
+}
+
+func TestLeaseExpiresWithoutRenewal(t *testing.T) {
+	server := miniredis.RunT(t)
+	first := newTestCoordinator(t, server, "first")
+	second := newTestCoordinator(t, server, "second")
+
+	acquire(t, first)
+
+	// Redis is the one to drop the key once the TTL passes.
+	server.FastForward(testTTL)
+	acquire(t, second)
+	if second.Epoch() <= first.Epoch() {
+		t.Fatalf("epoch %d after takeover, want more than %d", second.Epoch(), first.Epoch())
+	}
+
+	select {
+	case <-first.Lost():
+	case <-time.After(2 * testTTL):
+		t.Fatal("expired leader never noticed the takeover")
+	}
+}
+
+func TestTokensGrowAcrossExpiries(t *testing.T) {
+	server := miniredis.RunT(t)
+	replicas := []*Coordinator{
+		newTestCoordinator(t, server, "first"),
+		newTestCoordinator(t, server, "second"),
+	}
+
+	// The lease key goes with every expiry, the fencing token must not.
+	for i := int64(1); i <= 4; i++ {
+		c := replicas[i%2]
+		acquire(t, c)
+		if c.Epoch() != i || fencingToken(t, server, c) != i {
+			t.Fatalf("token is %d after %d elections, want %d", c.Epoch(), i, i)
+		}
+		server.FastForward(testTTL)
+	}
+}
+
+// stallProxy forwards connections to Redis and can stop passing requests on,
+// which makes the calls of its clients hang like behind a partition.
+type stallProxy struct {
+	listener net.Listener
+	target   string
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	stalled bool
+}
+
+func newStallProxy(t *testing.T, target string) *stallProxy {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	p := &stallProxy{listener: listener, target: target}
+	p.cond = sync.NewCond(&p.mu)
+	t.Cleanup(func() {
+		_ = listener.Close()
+		p.stall(false)
+	})
+
+	go p.accept()
+	return p
+}
+
+func (p *stallProxy) addr() string {
+	return p.listener.Addr().String()
+}
+
+func (p *stallProxy) stall(stalled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.stalled = stalled
+	p.cond.Broadcast()
+}
+
+func (p *stallProxy) accept() {
+	for {
+		client, err := p.listener.Accept()
+		if err != nil {
+			return
+		}
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			_ = client.Close()
+			continue
+		}
+		go func() {
+			defer client.Close()
+			defer server.Close()
+			_, _ = io.Copy(client, server)
+		}()
+		go p.forward(server, client)
+	}
+}
+
+// forward passes the requests from client on unless the proxy is stalled.
+func (p *stallProxy) forward(server, client net.Conn) {
+	defer client.Close()
+	defer server.Close()
+
+	buf := make([]byte, 4096)
+	for {
+		n, err := client.Read(buf)
+		if err != nil {
+			return
+		}
+
+		p.mu.Lock()
+		for p.stalled {
+			p.cond.Wait()
+		}
+		p.mu.Unlock()
+
+		if _, err := server.Write(buf[:n]); err != nil {
+			return
+		}
+	}
+}
+
+func TestLostWhenRenewalHangs(t *testing.T) {
+	server := miniredis.RunT(t)
+	proxy := newStallProxy(t, server.Addr())
+	first := newTestCoordinatorAt(t, proxy.addr(), "/election", "first")
+
+	acquire(t, first)
+	proxy.stall(true)
+	stalledAt := time.Now()
+
+	// The key outlives a renewal that never returns by at most the safety
+	// margin, leadership has to be gone by the time it expires.
+	select {
+	case <-first.Lost():
+	case <-time.After(2 * testTTL):
+		t.Fatal("leadership kept while the renewal hangs")
+	}
+	if elapsed := time.Since(stalledAt); elapsed > testTTL {
+		t.Fatalf("leadership lost %v after the stall, the lease lasts %v", elapsed, testTTL)
+	}
+}
@@ -0,0 +1,177 @@
+package zktest
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// maxPacketSize is the default jute.maxbuffer of ZooKeeper.
+const maxPacketSize = 1 << 20
+
+var errShortPacket = errors.New("short packet")
+
+// readPacket reads one length prefixed packet.
+func readPacket(r io.Reader) ([]byte, error) {
+	var size [4]byte
+	_, err := io.ReadFull(r, size[:])
+	if err != nil {
+		return nil, err
+	}
+
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxPacketSize {
+		return nil, fmt.Errorf("packet of %d bytes exceeds %d", n, maxPacketSize)
+	}
+
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// decoder reads jute encoded values, the first error sticks.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || len(d.buf) < n {
+		d.err = errShortPacket
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) int32() int32 {
+	b := d.next(4)
+	if b == nil {
+		return 0
+	}
+	return int32(binary.BigEndian.Uint32(b))
+}
+
+func (d *decoder) int64() int64 {
+	b := d.next(8)
+	if b == nil {
+		return 0
+	}
+	return int64(binary.BigEndian.Uint64(b))
+}
+
+func (d *decoder) bool() bool {
+	b := d.next(1)
+	return b != nil && b[0] != 0
+}
+
+// bytes returns nil for the -1 length of a null buffer.
+func (d *decoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return append([]byte(nil), d.next(int(n))...)
+}
+
+func (d *decoder) string() string {
+	n := d.int32()
+	if n < 0 {
+		return ""
+	}
+	return string(d.next(int(n)))
+}
+
+func (d *decoder) strings() []string {
+	n := d.int32()
+	if n < 0 || d.err != nil {
+		return nil
+	}
+
+	strs := make([]string, 0, min(int(n), len(d.buf)/4))
+	for i := int32(0); i < n && d.err == nil; i++ {
+		strs = append(strs, d.string())
+	}
+	return strs
+}
+
+func (d *decoder) acls() {
+	n := d.int32()
+	for i := int32(0); i < n && d.err == nil; i++ {
+		d.int32()
+		d.string()
+		d.string()
+	}
+}
+
+// encoder builds a length prefixed packet of jute encoded values.
+type encoder struct {
+	buf []byte
+}
+
+func newEncoder() *encoder {
+	return &encoder{buf: make([]byte, 4, 64)}
+}
+
+func (e *encoder) int32(v int32) *encoder {
+	e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v))
+	return e
+}
+
+func (e *encoder) int64(v int64) *encoder {
+	e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v))
+	return e
+}
+
+func (e *encoder) bytes(v []byte) *encoder {
+	if v == nil {
+		return e.int32(-1)
+	}
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+	return e
+}
+
+func (e *encoder) string(v string) *encoder {
+	e.int32(int32(len(v)))
+	e.buf = append(e.buf, v...)
+	return e
+}
+
+func (e *encoder) strings(v []string) *encoder {
+	e.int32(int32(len(v)))
+	for _, s := range v {
+		e.string(s)
+	}
+	return e
+}
+
+func (e *encoder) stat(s zk.Stat) *encoder {
+	return e.int64(s.Czxid).
+		int64(s.Mzxid).
+		int64(s.Ctime).
+		int64(s.Mtime).
+		int32(s.Version).
+		int32(s.Cversion).
+		int32(s.Aversion).
+		int64(s.EphemeralOwner).
+		int32(s.DataLength).
+		int32(s.NumChildren).
+		int64(s.Pzxid)
+}
+
+// packet fills in the length prefix and returns the packet.
+func (e *encoder) packet() []byte {
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+	return e.buf
+}
@@ -0,0 +1,725 @@
+// Package zktest runs an in-process ZooKeeper server for tests. It speaks the
+// part of the wire protocol go-zookeeper needs for leader election: sessions
+// with reconnects and expiry, create with ephemeral and sequential nodes,
+// delete, exists, get, set, getChildren and one-shot watches that survive
+// reconnects. Faults are injected with Expire, Drop, SetLatency and
+// SetAvailable.
+package zktest
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// Opcodes of the requests the server understands.
+const (
+	opCreate          int32 = 1
+	opDelete          int32 = 2
+	opExists          int32 = 3
+	opGetData         int32 = 4
+	opSetData         int32 = 5
+	opGetChildren     int32 = 8
+	opSync            int32 = 9
+	opPing            int32 = 11
+	opGetChildren2    int32 = 12
+	opCreateContainer int32 = 19
+	opCreateTTL       int32 = 21
+	opClose           int32 = -11
+	opSetAuth         int32 = 100
+	opSetWatches      int32 = 101
+)
+
+// Special xids of server packets.
+const (
+	xidWatchEvent int32 = -1
+	xidPing       int32 = -2
+)
+
+const (
+	// handshakeTimeout bounds the wait for the connect request.
+	handshakeTimeout = 10 * time.Second
+	// writeTimeout bounds every write to a client.
+	writeTimeout = 10 * time.Second
+	// defaultSessionTimeout is used when the client asks for none.
+	defaultSessionTimeout = 10 * time.Second
+	// outQueueSize is how many packets may wait for a slow client before it
+	// is disconnected.
+	outQueueSize = 1024
+	// firstSessionID looks like the IDs of a real server.
+	firstSessionID = 0x100000000
+)
+
+// ErrNoSession is returned by the fault injection methods for unknown
+// sessions.
+var ErrNoSession = errors.New("zktest: no such session")
+
+// NewServer starts a server on a random local port.
+func NewServer() (*Server, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("listen: %w", err)
+	}
+
+	s := &Server{
+		listener: listener,
+		tree:     newTree(),
+		sessions: make(map[int64]*session),
+		conns:    make(map[*conn]struct{}),
+		nextID:   firstSessionID,
+	}
+
+	s.wg.Add(1)
+	go s.accept()
+
+	return s, nil
+}
+
+// Server is an in-memory single node ZooKeeper.
+type Server struct {
+	listener net.Listener
+	wg       sync.WaitGroup
+
+	mu       sync.Mutex
+	tree     *tree
+	sessions map[int64]*session
+	conns    map[*conn]struct{}
+	nextID   int64
+	latency  time.Duration
+	down     bool
+	closed   bool
+}
+
+type session struct {
+	id      int64
+	passwd  []byte
+	timeout time.Duration
+
+	// conn is nil while the client is disconnected, expiry is armed then.
+	conn    *conn
+	expiry  *time.Timer
+	watches [3]map[string]struct{}
+}
+
+// Addr returns the host:port to pass to zk.Connect.
+func (s *Server) Addr() string {
+	return s.listener.Addr().String()
+}
+
+// Close disconnects every client and stops the server. Sessions do not expire
+// afterwards.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	for c := range s.conns {
+		c.close()
+	}
+	for _, sess := range s.sessions {
+		if sess.expiry != nil {
+			sess.expiry.Stop()
+		}
+	}
+	s.mu.Unlock()
+
+	err := s.listener.Close()
+	s.wg.Wait()
+	return err
+}
+
+// Sessions returns the IDs of the live sessions, connected or not.
+func (s *Server) Sessions() []int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ids := make([]int64, 0, len(s.sessions))
+	for id := range s.sessions {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// Expire ends the session as if it timed out: its ephemeral nodes are deleted
+// and its client learns about the expiry when it reconnects.
+func (s *Server) Expire(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return ErrNoSession
+	}
+	s.expire(sess)
+	return nil
+}
+
+// Drop closes the connection of the session. The session survives and the
+// client reconnects to it unless it stays away longer than its timeout.
+func (s *Server) Drop(id int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	sess, ok := s.sessions[id]
+	if !ok {
+		return ErrNoSession
+	}
+	if sess.conn != nil {
+		sess.conn.close()
+	}
+	return nil
+}
+
+// DropAll closes the connections of all sessions.
+func (s *Server) DropAll() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for c := range s.conns {
+		c.close()
+	}
+}
+
+// SetLatency delays the processing of every following request by d.
+func (s *Server) SetLatency(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.latency = d
+}
+
+// SetAvailable(false) drops every connection and refuses new ones until
+// SetAvailable(true). Sessions keep expiring meanwhile.
+func (s *Server) SetAvailable(available bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.down = !available
+	if s.down {
+		for c := range s.conns {
+			c.close()
+		}
+	}
+}
+
+// Get returns the data and stat of the node at p.
+func (s *Server) Get(p string) ([]byte, *zk.Stat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	node, ok := s.tree.nodes[p]
+	if !ok {
+		return nil, nil, zk.ErrNoNode
+	}
+	stat := node.stat
+	return slices.Clone(node.data), &stat, nil
+}
+
+// Children returns the sorted names of the children of p.
+func (s *Server) Children(p string) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	children, _, code := s.tree.children(p)
+	if code != errOk {
+		return nil, zk.ErrNoNode
+	}
+	return children, nil
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+
+	for {
+		nc, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		s.wg.Add(1)
+		go s.serve(nc)
+	}
+}
+
+// serve runs a single client connection from the handshake on.
+func (s *Server) serve(nc net.Conn) {
+	defer s.wg.Done()
+
+	c := &conn{
+		Conn: nc,
+		out:  make(chan []byte, outQueueSize),
+		done: make(chan struct{}),
+	}
+	defer c.close()
+
+	s.mu.Lock()
+	if s.down || s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.conns[c] = struct{}{}
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+	}()
+
+	sess, ok := s.handshake(c)
+	if !ok {
+		return
+	}
+	go c.writeLoop()
+
+	for {
+		_ = nc.SetReadDeadline(time.Now().Add(sess.timeout))
+		pkt, err := readPacket(nc)
+		if err != nil {
+			s.mu.Lock()
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() && sess.conn == c {
+				// No heartbeat within the session timeout.
+				s.expire(sess)
+			} else {
+				s.detach(sess, c)
+			}
+			s.mu.Unlock()
+			return
+		}
+
+		s.mu.Lock()
+		latency := s.latency
+		s.mu.Unlock()
+		if latency > 0 {
+			select {
+			case <-c.done:
+				return
+			case <-time.After(latency):
+			}
+		}
+
+		if s.handle(sess, c, pkt) {
+			// The reply to close is flushed before the connection closes.
+			<-c.done
+			return
+		}
+	}
+}
+
+// handshake answers the connect request. A request for an unknown or expired
+// session gets session 0 back, which go-zookeeper reports as StateExpired.
+func (s *Server) handshake(c *conn) (*session, bool) {
+	_ = c.SetReadDeadline(time.Now().Add(handshakeTimeout))
+	pkt, err := readPacket(c)
+	if err != nil {
+		return nil, false
+	}
+
+	d := &decoder{buf: pkt}
+	d.int32() // protocol version
+	d.int64() // last zxid seen
+	timeout := time.Duration(d.int32()) * time.Millisecond
+	id := d.int64()
+	passwd := d.bytes()
+	if d.err != nil {
+		return nil, false
+	}
+
+	s.mu.Lock()
+	sess := s.attach(c, id, passwd, timeout)
+	s.mu.Unlock()
+
+	res := newEncoder().int32(0)
+	if sess != nil {
+		res.int32(int32(sess.timeout.Milliseconds())).int64(sess.id).bytes(sess.passwd)
+	} else {
+		res.int32(0).int64(0).bytes(make([]byte, 16))
+	}
+
+	_ = c.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err = c.Write(res.packet())
+	if err != nil && sess != nil {
+		s.mu.Lock()
+		s.detach(sess, c)
+		s.mu.Unlock()
+	}
+	return sess, err == nil && sess != nil
+}
+
+// attach binds c to the session id, or to a new session when id is 0. s.mu
+// must be held.
+func (s *Server) attach(c *conn, id int64, passwd []byte, timeout time.Duration) *session {
+	if id != 0 {
+		sess, ok := s.sessions[id]
+		if !ok || !bytes.Equal(sess.passwd, passwd) {
+			return nil
+		}
+
+		if sess.expiry != nil {
+			sess.expiry.Stop()
+			sess.expiry = nil
+		}
+		if sess.conn != nil {
+			sess.conn.close()
+		}
+		sess.conn = c
+		return sess
+	}
+
+	if timeout <= 0 {
+		timeout = defaultSessionTimeout
+	}
+	passwd = make([]byte, 16)
+	_, _ = rand.Read(passwd)
+
+	s.nextID++
+	sess := &session{
+		id:      s.nextID,
+		passwd:  passwd,
+		timeout: timeout,
+		conn:    c,
+	}
+	for i := range sess.watches {
+		sess.watches[i] = make(map[string]struct{})
+	}
+	s.sessions[sess.id] = sess
+	return sess
+}
+
+// detach unbinds c from its session and arms the session expiry. Watches are
+// dropped, the client sets them again after reconnecting. s.mu must be held.
+func (s *Server) detach(sess *session, c *conn) {
+	if s.sessions[sess.id] != sess || sess.conn != c {
+		return
+	}
+
+	sess.conn = nil
+	for _, watches := range sess.watches {
+		clear(watches)
+	}
+	if s.closed {
+		return
+	}
+	sess.expiry = time.AfterFunc(sess.timeout, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		if s.sessions[sess.id] == sess && sess.conn == nil && !s.closed {
+			s.expire(sess)
+		}
+	})
+}
+
+// expire ends the session and closes its connection. s.mu must be held.
+func (s *Server) expire(sess *session) {
+	conn := sess.conn
+	s.endSession(sess)
+	if conn != nil {
+		conn.close()
+	}
+}
+
+// endSession forgets the session and deletes its ephemeral nodes. s.mu must be
+// held.
+func (s *Server) endSession(sess *session) {
+	if sess.expiry != nil {
+		sess.expiry.Stop()
+	}
+	delete(s.sessions, sess.id)
+	sess.conn = nil
+
+	for _, p := range s.tree.ephemerals(sess.id) {
+		if s.tree.delete(p, -1) == errOk {
+			s.deleted(p)
+		}
+	}
+}
+
+// handle serves a single request and reports whether the session was closed.
+func (s *Server) handle(sess *session, c *conn, pkt []byte) bool {
+	d := &decoder{buf: pkt}
+	xid, op := d.int32(), d.int32()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.sessions[sess.id] != sess || sess.conn != c {
+		// Expired or taken over by a newer connection meanwhile.
+		c.close()
+		return false
+	}
+
+	res := newEncoder()
+	code := errOk
+	var notify func()
+
+	switch op {
+	case opPing:
+		c.send(newEncoder().int32(xidPing).int64(s.tree.zxid).int32(errOk).packet())
+		return false
+
+	case opCreate, opCreateContainer, opCreateTTL:
+		p, data := d.string(), d.bytes()
+		d.acls()
+		flags := d.int32()
+		if d.err != nil {
+			code = errMarshalling
+			break
+		}
+
+		var created string
+		created, code = s.tree.create(p, data, flags, sess.id)
+		if code == errOk {
+			res.string(created)
+			notify = func() { s.created(created) }
+		}
+
+	case opDelete:
+		p, version := d.string(), d.int32()
+		if d.err != nil {
+			code = errMarshalling
+			break
+		}
+
+		code = s.tree.delete(p, version)
+		if code == errOk {
+			notify = func() { s.deleted(p) }
+		}
+
+	case opExists:
+		p, watch := d.string(), d.bool()
+		if d.err != nil {
+			code = errMarshalling
+			break
+		}
+
+		node, ok := s.tree.nodes[p]
+		switch {
+		case ok:
+			res.stat(node.stat)
+			if watch {
+				sess.watches[watchData][p] = struct{}{}
+			}
+		default:
+			code = errNoNode
+			if watch {
+				sess.watches[watchExist][p] = struct{}{}
+			}
+		}
+
+	case opGetData:
+		p, watch := d.string(), d.bool()
+		if d.err != nil {
+			code = errMarshalling
+			break
+		}
+
+		node, ok := s.tree.nodes[p]
+		if !ok {
+			code = errNoNode
+			break
+		}
+		res.bytes(node.data).stat(node.stat)
+		if watch {
+			sess.watches[watchData][p] = struct{}{}
+		}
+
+	case opSetData:
+		p, data, version := d.string(), d.bytes(), d.int32()
+		if d.err != nil {
+			code = errMarshalling
+			break
+		}
+
+		var stat zk.Stat
+		stat, code = s.tree.setData(p, data, version)
+		if code == errOk {
+			res.stat(stat)
+			notify = func() { s.trigger(p, zk.EventNodeDataChanged, watchData, watchExist) }
+		}
+
+	case opGetChildren, opGetChildren2:
+		p, watch := d.string(), d.bool()
+		if d.err != nil {
+			code = errMarshalling
+			break
+		}
+
+		var children []string
+		var stat zk.Stat
+		children, stat, code = s.tree.children(p)
+		if code != errOk {
+			break
+		}
+		res.strings(children)
+		if op == opGetChildren2 {
+			res.stat(stat)
+		}
+		if watch {
+			sess.watches[watchChild][p] = struct{}{}
+		}
+
+	case opSync:
+		res.string(d.string())
+
+	case opSetAuth:
+
+	case opSetWatches:
+		relativeZxid := d.int64()
+		dataWatches, existWatches, childWatches := d.strings(), d.strings(), d.strings()
+		if d.err != nil {
+			code = errMarshalling
+			break
+		}
+		notify = func() { s.setWatches(sess, relativeZxid, dataWatches, existWatches, childWatches) }
+
+	case opClose:
+		c.send(newEncoder().int32(xid).int64(s.tree.zxid).int32(errOk).packet())
+		c.send(nil)
+		s.endSession(sess)
+		return true
+
+	default:
+		code = errUnimplemented
+	}
+
+	reply := newEncoder().int32(xid).int64(s.tree.zxid).int32(code)
+	if code == errOk {
+		reply.buf = append(reply.buf, res.buf[4:]...)
+	}
+	c.send(reply.packet())
+
+	if notify != nil {
+		notify()
+	}
+	return false
+}
+
+// setWatches restores the watches of a reconnected client, firing those whose
+// nodes changed after relativeZxid right away. s.mu must be held.
+func (s *Server) setWatches(sess *session, relativeZxid int64, dataWatches, existWatches, childWatches []string) {
+	for _, p := range dataWatches {
+		node, ok := s.tree.nodes[p]
+		switch {
+		case !ok:
+			s.notify(sess, p, zk.EventNodeDeleted)
+		case node.stat.Mzxid > relativeZxid:
+			s.notify(sess, p, zk.EventNodeDataChanged)
+		default:
+			sess.watches[watchData][p] = struct{}{}
+		}
+	}
+
+	for _, p := range existWatches {
+		if _, ok := s.tree.nodes[p]; ok {
+			s.notify(sess, p, zk.EventNodeCreated)
+		} else {
+			sess.watches[watchExist][p] = struct{}{}
+		}
+	}
+
+	for _, p := range childWatches {
+		node, ok := s.tree.nodes[p]
+		switch {
+		case !ok:
+			s.notify(sess, p, zk.EventNodeDeleted)
+		case node.stat.Pzxid > relativeZxid:
+			s.notify(sess, p, zk.EventNodeChildrenChanged)
+		default:
+			sess.watches[watchChild][p] = struct{}{}
+		}
+	}
+}
+
+// created fires the watches of a new node at p. s.mu must be held.
+func (s *Server) created(p string) {
+	s.trigger(p, zk.EventNodeCreated, watchExist)
+	s.trigger(path.Dir(p), zk.EventNodeChildrenChanged, watchChild)
+}
+
+// deleted fires the watches of the removed node at p. s.mu must be held.
+func (s *Server) deleted(p string) {
+	s.trigger(p, zk.EventNodeDeleted, watchData, watchExist, watchChild)
+	s.trigger(path.Dir(p), zk.EventNodeChildrenChanged, watchChild)
+}
+
+// trigger sends typ once to every session watching p with one of kinds and
+// removes those watches. s.mu must be held.
+func (s *Server) trigger(p string, typ zk.EventType, kinds ...watchKind) {
+	for _, sess := range s.sessions {
+		watched := false
+		for _, kind := range kinds {
+			if _, ok := sess.watches[kind][p]; ok {
+				delete(sess.watches[kind], p)
+				watched = true
+			}
+		}
+		if watched {
+			s.notify(sess, p, typ)
+		}
+	}
+}
+
+func (s *Server) notify(sess *session, p string, typ zk.EventType) {
+	if sess.conn == nil {
+		return
+	}
+
+	sess.conn.send(newEncoder().
+		int32(xidWatchEvent).int64(-1).int32(errOk).
+		int32(int32(typ)).int32(stateSyncConnected).string(p).
+		packet())
+}
+
+// conn is a client connection, replies are written by writeLoop in order.
+type conn struct {
+	net.Conn
+	out  chan []byte
+	done chan struct{}
+	once sync.Once
+}
+
+// send queues pkt, a nil pkt closes the connection once everything before it
+// is written. A client that does not keep up is disconnected.
+func (c *conn) send(pkt []byte) {
+	select {
+	case c.out <- pkt:
+	default:
+		c.close()
+	}
+}
+
+func (c *conn) writeLoop() {
+	for {
+		select {
+		case <-c.done:
+			return
+		case pkt := <-c.out:
+			if pkt == nil {
+				c.close()
+				return
+			}
+
+			_ = c.SetWriteDeadline(time.Now().Add(writeTimeout))
+			_, err := c.Write(pkt)
+			if err != nil {
+				c.close()
+				return
+			}
+		}
+	}
+}
+
+func (c *conn) close() {
+	c.once.Do(func() {
+		close(c.done)
+		_ = c.Conn.Close()
+	})
+}
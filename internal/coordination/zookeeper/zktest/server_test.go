@@ -0,0 +1,234 @@
+package zktest
+
+import (
+	"errors"
+	"io"
+	"log"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+const testSessionTimeout = 2 * time.Second
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+
+	srv, err := NewServer()
+	if err != nil {
+		t.Fatalf("start server: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv
+}
+
+// connect returns a client with an established session and its events.
+func connect(t *testing.T, srv *Server) (*zk.Conn, <-chan zk.Event) {
+	t.Helper()
+
+	conn, events, err := zk.Connect([]string{srv.Addr()}, testSessionTimeout,
+		zk.WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	waitState(t, events, zk.StateHasSession)
+	return conn, events
+}
+
+func waitState(t *testing.T, events <-chan zk.Event, state zk.State) {
+	t.Helper()
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.Type == zk.EventSession && ev.State == state {
+				return
+			}
+		case <-timeout:
+			t.Fatalf("no %v session event", state)
+		}
+	}
+}
+
+func waitWatch(t *testing.T, watch <-chan zk.Event, typ zk.EventType, p string) {
+	t.Helper()
+
+	select {
+	case ev := <-watch:
+		if ev.Type != typ || ev.Path != p {
+			t.Fatalf("watch fired with %v on %s, want %v on %s", ev.Type, ev.Path, typ, p)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("watch on %s did not fire", p)
+	}
+}
+
+func TestNodes(t *testing.T) {
+	srv := newTestServer(t)
+	conn, _ := connect(t, srv)
+	acl := zk.WorldACL(zk.PermAll)
+
+	if _, err := conn.Create("/election", []byte("root"), 0, acl); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	if _, err := conn.Create("/election", nil, 0, acl); !errors.Is(err, zk.ErrNodeExists) {
+		t.Fatalf("create twice returned %v, want ErrNodeExists", err)
+	}
+	if _, err := conn.Create("/missing/child", nil, 0, acl); !errors.Is(err, zk.ErrNoNode) {
+		t.Fatalf("create without parent returned %v, want ErrNoNode", err)
+	}
+
+	var names []string
+	for range 3 {
+		name, err := conn.Create("/election/candidate-", nil, zk.FlagEphemeral|zk.FlagSequence, acl)
+		if err != nil {
+			t.Fatalf("create sequential: %v", err)
+		}
+		names = append(names, name)
+	}
+	want := []string{"/election/candidate-0000000000", "/election/candidate-0000000001", "/election/candidate-0000000002"}
+	if !slices.Equal(names, want) {
+		t.Fatalf("sequential nodes are %v, want %v", names, want)
+	}
+
+	children, _, err := conn.Children("/election")
+	if err != nil {
+		t.Fatalf("children: %v", err)
+	}
+	slices.Sort(children)
+	if !slices.Equal(children, []string{"candidate-0000000000", "candidate-0000000001", "candidate-0000000002"}) {
+		t.Fatalf("children are %v", children)
+	}
+
+	stat, err := conn.Set("/election", []byte("updated"), 0)
+	if err != nil {
+		t.Fatalf("set: %v", err)
+	}
+	if _, err := conn.Set("/election", nil, 0); !errors.Is(err, zk.ErrBadVersion) {
+		t.Fatalf("set with a stale version returned %v, want ErrBadVersion", err)
+	}
+	data, got, err := conn.Get("/election")
+	if err != nil || string(data) != "updated" || got.Version != stat.Version {
+		t.Fatalf("get returned %q, version %d, %v", data, got.Version, err)
+	}
+
+	if err := conn.Delete("/election", -1); !errors.Is(err, zk.ErrNotEmpty) {
+		t.Fatalf("delete with children returned %v, want ErrNotEmpty", err)
+	}
+	if err := conn.Delete(names[0], -1); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	if ok, _, err := conn.Exists(names[0]); err != nil || ok {
+		t.Fatalf("exists after delete returned %v, %v", ok, err)
+	}
+}
+
+func TestWatches(t *testing.T) {
+	srv := newTestServer(t)
+	conn, _ := connect(t, srv)
+	other, _ := connect(t, srv)
+	acl := zk.WorldACL(zk.PermAll)
+
+	ok, _, exists, err := conn.ExistsW("/election")
+	if err != nil || ok {
+		t.Fatalf("exists returned %v, %v", ok, err)
+	}
+	if _, err := other.Create("/election", nil, 0, acl); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	waitWatch(t, exists, zk.EventNodeCreated, "/election")
+
+	_, _, children, err := conn.ChildrenW("/election")
+	if err != nil {
+		t.Fatalf("children: %v", err)
+	}
+	name, err := other.Create("/election/candidate-", nil, zk.FlagEphemeral|zk.FlagSequence, acl)
+	if err != nil {
+		t.Fatalf("create candidate: %v", err)
+	}
+	waitWatch(t, children, zk.EventNodeChildrenChanged, "/election")
+
+	_, _, data, err := conn.GetW(name)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+	if err := other.Delete(name, -1); err != nil {
+		t.Fatalf("delete: %v", err)
+	}
+	waitWatch(t, data, zk.EventNodeDeleted, name)
+}
+
+func TestExpireDeletesEphemerals(t *testing.T) {
+	srv := newTestServer(t)
+	leader, leaderEvents := connect(t, srv)
+	follower, _ := connect(t, srv)
+	acl := zk.WorldACL(zk.PermAll)
+
+	if _, err := leader.Create("/election", nil, 0, acl); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	name, err := leader.Create("/election/candidate-", nil, zk.FlagEphemeral|zk.FlagSequence, acl)
+	if err != nil {
+		t.Fatalf("create candidate: %v", err)
+	}
+	_, _, deleted, err := follower.GetW(name)
+	if err != nil {
+		t.Fatalf("get: %v", err)
+	}
+
+	if err := srv.Expire(leader.SessionID()); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	waitWatch(t, deleted, zk.EventNodeDeleted, name)
+	waitState(t, leaderEvents, zk.StateExpired)
+
+	if _, _, err := srv.Get("/election"); err != nil {
+		t.Fatalf("persistent node is gone after expiry: %v", err)
+	}
+	if err := srv.Expire(leader.SessionID()); !errors.Is(err, ErrNoSession) {
+		t.Fatalf("expire twice returned %v, want ErrNoSession", err)
+	}
+}
+
+func TestDropKeepsSession(t *testing.T) {
+	srv := newTestServer(t)
+	conn, events := connect(t, srv)
+	other, _ := connect(t, srv)
+	acl := zk.WorldACL(zk.PermAll)
+
+	name, err := conn.Create("/candidate", nil, zk.FlagEphemeral, acl)
+	if err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	_, _, watch, err := conn.ExistsW("/other")
+	if err != nil {
+		t.Fatalf("exists: %v", err)
+	}
+	id := conn.SessionID()
+
+	if err := srv.Drop(id); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	// The node appears around the reconnect, the watch has to survive it
+	// either way.
+	if _, err := other.Create("/other", nil, 0, acl); err != nil {
+		t.Fatalf("create: %v", err)
+	}
+	waitState(t, events, zk.StateHasSession)
+	if conn.SessionID() != id {
+		t.Fatalf("session %d after reconnect, want %d", conn.SessionID(), id)
+	}
+	waitWatch(t, watch, zk.EventNodeCreated, "/other")
+
+	if _, _, err := srv.Get(name); err != nil {
+		t.Fatalf("ephemeral node is gone after reconnect: %v", err)
+	}
+	if !slices.Contains(srv.Sessions(), id) {
+		t.Fatalf("session %d is not live", id)
+	}
+}
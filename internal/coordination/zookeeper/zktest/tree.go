@@ -0,0 +1,182 @@
+package zktest
+
+import (
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/go-zookeeper/zk"
+)
+
+// Error codes of the ZooKeeper protocol.
+const (
+	errOk                      int32 = 0
+	errMarshalling             int32 = -5
+	errUnimplemented           int32 = -6
+	errBadArguments            int32 = -8
+	errNoNode                  int32 = -101
+	errBadVersion              int32 = -103
+	errNoChildrenForEphemerals int32 = -108
+	errNodeExists              int32 = -110
+	errNotEmpty                int32 = -111
+	errSessionExpired          int32 = -112
+)
+
+// stateSyncConnected is the keeper state sent with watch events.
+const stateSyncConnected int32 = 3
+
+type watchKind int
+
+const (
+	watchData watchKind = iota
+	watchExist
+	watchChild
+)
+
+type znode struct {
+	data     []byte
+	stat     zk.Stat
+	children map[string]struct{}
+}
+
+// tree is the data tree of the server, it is guarded by Server.mu.
+type tree struct {
+	zxid  int64
+	nodes map[string]*znode
+}
+
+func newTree() *tree {
+	return &tree{
+		nodes: map[string]*znode{
+			"/": {children: make(map[string]struct{})},
+		},
+	}
+}
+
+func validPath(p string) bool {
+	return p == "/" || (strings.HasPrefix(p, "/") && !strings.HasSuffix(p, "/") && path.Clean(p) == p)
+}
+
+func (t *tree) create(p string, data []byte, flags int32, owner int64) (string, int32) {
+	if !validPath(p) || p == "/" {
+		return "", errBadArguments
+	}
+
+	parentPath := path.Dir(p)
+	parent, ok := t.nodes[parentPath]
+	if !ok {
+		return "", errNoNode
+	}
+	if parent.stat.EphemeralOwner != 0 {
+		return "", errNoChildrenForEphemerals
+	}
+
+	if flags&zk.FlagSequence != 0 {
+		p = fmt.Sprintf("%s%010d", p, parent.stat.Cversion)
+	}
+	if _, ok := t.nodes[p]; ok {
+		return "", errNodeExists
+	}
+
+	t.zxid++
+	now := time.Now().UnixMilli()
+	node := &znode{
+		data:     data,
+		children: make(map[string]struct{}),
+		stat: zk.Stat{
+			Czxid:      t.zxid,
+			Mzxid:      t.zxid,
+			Pzxid:      t.zxid,
+			Ctime:      now,
+			Mtime:      now,
+			DataLength: int32(len(data)),
+		},
+	}
+	if flags&zk.FlagEphemeral != 0 {
+		node.stat.EphemeralOwner = owner
+	}
+	t.nodes[p] = node
+
+	parent.children[path.Base(p)] = struct{}{}
+	parent.stat.Cversion++
+	parent.stat.NumChildren++
+	parent.stat.Pzxid = t.zxid
+
+	return p, errOk
+}
+
+func (t *tree) delete(p string, version int32) int32 {
+	if p == "/" {
+		return errBadArguments
+	}
+
+	node, ok := t.nodes[p]
+	if !ok {
+		return errNoNode
+	}
+	if version != -1 && version != node.stat.Version {
+		return errBadVersion
+	}
+	if len(node.children) > 0 {
+		return errNotEmpty
+	}
+
+	t.zxid++
+	delete(t.nodes, p)
+
+	parent := t.nodes[path.Dir(p)]
+	delete(parent.children, path.Base(p))
+	parent.stat.Cversion++
+	parent.stat.NumChildren--
+	parent.stat.Pzxid = t.zxid
+
+	return errOk
+}
+
+func (t *tree) setData(p string, data []byte, version int32) (zk.Stat, int32) {
+	node, ok := t.nodes[p]
+	if !ok {
+		return zk.Stat{}, errNoNode
+	}
+	if version != -1 && version != node.stat.Version {
+		return zk.Stat{}, errBadVersion
+	}
+
+	t.zxid++
+	node.data = data
+	node.stat.Version++
+	node.stat.Mzxid = t.zxid
+	node.stat.Mtime = time.Now().UnixMilli()
+	node.stat.DataLength = int32(len(data))
+
+	return node.stat, errOk
+}
+
+func (t *tree) children(p string) ([]string, zk.Stat, int32) {
+	node, ok := t.nodes[p]
+	if !ok {
+		return nil, zk.Stat{}, errNoNode
+	}
+
+	names := make([]string, 0, len(node.children))
+	for name := range node.children {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	return names, node.stat, errOk
+}
+
+// ephemerals returns the nodes owned by session, they never have children.
+func (t *tree) ephemerals(session int64) []string {
+	var paths []string
+	for p, node := range t.nodes {
+		if node.stat.EphemeralOwner == session {
+			paths = append(paths, p)
+		}
+	}
+	slices.Sort(paths)
+	return paths
+}
@@ -0,0 +1,581 @@
+package zookeeper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+	"github.com/go-zookeeper/zk"
+)
+
+var _ coordination.Coordinator = &Coordinator{}
+
+// candidatePrefix is the name prefix of the sequential ephemeral nodes created
+// by every candidate under the election path.
+const candidatePrefix = "candidate-"
+
+type Config struct {
+	Servers        []string
+	SessionTimeout time.Duration
+	ElectionPath   string
+	// PollInterval re-checks the election in addition to the predecessor
+	// watch. Zero disables polling.
+	PollInterval time.Duration
+	Identity     string
+	// Clock drives the polling, defaults to the real clock.
+	Clock extra.Clock
+}
+
+func New(cfg Config, logger *slog.Logger) *Coordinator {
+	if cfg.Clock == nil {
+		cfg.Clock = extra.NewClock()
+	}
+
+	lost := make(chan struct{})
+	close(lost)
+
+	return &Coordinator{
+		cfg:          cfg,
+		logger:       logger.With("subsystem", "ZookeeperCoordinator"),
+		lost:         lost,
+		pollInterval: cfg.PollInterval,
+	}
+}
+
+// Coordinator elects the leader with the standard ZooKeeper recipe: every
+// candidate creates an EPHEMERAL|SEQUENTIAL node under the election path and
+// the one with the lowest sequence number is the leader.
+type Coordinator struct {
+	cfg    Config
+	logger *slog.Logger
+
+	mu       sync.Mutex
+	conn     *zk.Conn
+	node     string
+	epoch    int64
+	lost     chan struct{}
+	loseOnce *sync.Once
+	// ready is closed while conn has a session, expired is set once the
+	// session of conn expired and until conn gets a new one.
+	ready   chan struct{}
+	expired bool
+	session int64
+
+	pollInterval time.Duration
+	pollTicker   extra.Ticker
+}
+
+// SetPollInterval changes the interval of the election re-check, a running
+// campaign is reset to it. Polling can not be switched on or off this way.
+func (c *Coordinator) SetPollInterval(interval time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.pollInterval <= 0 || interval <= 0 {
+		return
+	}
+	c.pollInterval = interval
+	if c.pollTicker != nil {
+		c.pollTicker.Reset(interval)
+	}
+}
+
+// Connect waits for the session of the current connection to come back when
+// it is only disconnected, the client reconnects within the session timeout
+// by itself. A new connection is only made when there is none or its session
+// expired, and the old one is closed then.
+func (c *Coordinator) Connect(ctx context.Context) error {
+	c.mu.Lock()
+	conn, ready, expired := c.conn, c.ready, c.expired
+	c.mu.Unlock()
+
+	if conn != nil && !expired {
+		return c.awaitSession(ctx, conn, ready)
+	}
+
+	c.Close()
+
+	conn, events, err := zk.Connect(c.cfg.Servers, c.cfg.SessionTimeout)
+	if err != nil {
+		return fmt.Errorf("connect to zookeeper: %w", err)
+	}
+
+	ready = make(chan struct{})
+	c.mu.Lock()
+	c.conn, c.node, c.ready, c.expired, c.session = conn, "", ready, false, 0
+	c.mu.Unlock()
+
+	go c.watchSession(conn, events)
+
+	err = c.awaitSession(ctx, conn, ready)
+	if err != nil {
+		c.Close()
+		return err
+	}
+	return nil
+}
+
+func (c *Coordinator) awaitSession(ctx context.Context, conn *zk.Conn, ready <-chan struct{}) error {
+	timer := time.NewTimer(c.cfg.SessionTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return fmt.Errorf("no zookeeper session within %s", c.cfg.SessionTimeout)
+	case <-ready:
+	}
+
+	c.logger.Info("zookeeper session established", slog.Int64("session", conn.SessionID()))
+	return nil
+}
+
+func (c *Coordinator) Connected() bool {
+	c.mu.Lock()
+	conn, expired := c.conn, c.expired
+	c.mu.Unlock()
+
+	return conn != nil && !expired && conn.State() == zk.StateHasSession
+}
+
+func (c *Coordinator) Acquire(ctx context.Context) error {
+	conn := c.connection()
+	if conn == nil {
+		return coordination.ErrNotConnected
+	}
+
+	node, err := c.ensureCandidate(conn)
+	if err != nil {
+		return err
+	}
+
+	err = c.campaign(ctx, conn, node)
+	if err != nil {
+		return err
+	}
+
+	// The czxid of our node is ordered after every node created before it,
+	// including the ones of all previous leaders.
+	exists, stat, err := conn.Exists(node)
+	if err != nil {
+		return fmt.Errorf("stat candidate node %s: %w", node, err)
+	}
+	if !exists {
+		return fmt.Errorf("candidate node %s is gone", node)
+	}
+
+	lost := make(chan struct{})
+	once := &sync.Once{}
+	c.mu.Lock()
+	c.lost, c.loseOnce, c.epoch = lost, once, stat.Czxid
+	c.mu.Unlock()
+
+	go c.watchNode(conn, node, lost, once)
+
+	return nil
+}
+
+func (c *Coordinator) Lost() <-chan struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.lost
+}
+
+func (c *Coordinator) Epoch() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.epoch
+}
+
+func (c *Coordinator) Leader(context.Context) (string, error) {
+	conn := c.connection()
+	if conn == nil {
+		return "", coordination.ErrNotConnected
+	}
+
+	candidates, err := c.candidates(conn)
+	if errors.Is(err, zk.ErrNoNode) {
+		// Nobody has campaigned yet, the election path is created on Acquire.
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(candidates) == 0 {
+		return "", nil
+	}
+
+	data, _, err := conn.Get(path.Join(c.cfg.ElectionPath, candidates[0]))
+	if errors.Is(err, zk.ErrNoNode) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("get leader node: %w", err)
+	}
+
+	identity, _, _ := strings.Cut(strings.TrimPrefix(string(data), "hostname: "), ",")
+	return identity, nil
+}
+
+func (c *Coordinator) Release(context.Context) error {
+	c.mu.Lock()
+	conn, node := c.conn, c.node
+	c.node = ""
+	c.mu.Unlock()
+
+	c.loseLeadership()
+
+	if conn == nil || node == "" {
+		return nil
+	}
+
+	exists, stat, err := conn.Exists(node)
+	if err != nil {
+		return fmt.Errorf("check candidate node %s: %w", node, err)
+	}
+	if !exists {
+		return nil
+	}
+
+	// After an expiry the node is gone or, with a reused name, someone else's.
+	if stat.EphemeralOwner != conn.SessionID() {
+		c.logger.Warn("candidate node is owned by another session, not deleting it",
+			slog.String("node", node),
+			slog.Int64("owner", stat.EphemeralOwner),
+			slog.Int64("session", conn.SessionID()))
+		return nil
+	}
+
+	err = conn.Delete(node, stat.Version)
+	if err != nil && !errors.Is(err, zk.ErrNoNode) {
+		return fmt.Errorf("delete candidate node %s: %w", node, err)
+	}
+	return nil
+}
+
+func (c *Coordinator) Close() error {
+	c.mu.Lock()
+	conn := c.conn
+	c.conn, c.node, c.ready, c.expired, c.session = nil, "", nil, false, 0
+	c.mu.Unlock()
+
+	c.loseLeadership()
+
+	if conn != nil {
+		conn.Close()
+	}
+	return nil
+}
+
+func (c *Coordinator) connection() *zk.Conn {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.conn
+}
+
+func (c *Coordinator) loseLeadership() {
+	c.mu.Lock()
+	lost, once := c.lost, c.loseOnce
+	c.mu.Unlock()
+
+	if once != nil {
+		once.Do(func() { close(lost) })
+	}
+}
+
+// watchSession drains the session events of conn until it is closed. While
+// disconnected the session, and with it our ephemeral node, may survive, so
+// leadership is only dropped once the session expired or the disconnect
+// outlasted suspendTimeout.
+func (c *Coordinator) watchSession(conn *zk.Conn, events <-chan zk.Event) {
+	var suspended <-chan time.Time
+	for {
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return
+			}
+			if ev.Type != zk.EventSession {
+				continue
+			}
+
+			c.logger.Info("zookeeper session event", slog.String("state", ev.State.String()))
+
+			switch ev.State {
+			case zk.StateHasSession:
+				suspended = nil
+				if c.sessionEstablished(conn) {
+					// The client replaced the expired session by itself.
+					c.loseLeadership()
+				}
+			case zk.StateDisconnected:
+				if suspended == nil {
+					suspended = time.After(c.suspendTimeout())
+				}
+				c.sessionSuspended(conn)
+			case zk.StateExpired:
+				suspended = nil
+				if c.current(conn) {
+					c.mu.Lock()
+					c.expired, c.node = true, ""
+					c.mu.Unlock()
+					c.loseLeadership()
+				}
+			}
+
+		case <-suspended:
+			suspended = nil
+			if c.current(conn) {
+				c.logger.Warn("zookeeper session suspended for too long, dropping leadership",
+					slog.Duration("timeout", c.suspendTimeout()))
+				c.loseLeadership()
+			}
+		}
+	}
+}
+
+// SuspendFraction of the session timeout is how long leadership is kept while
+// disconnected. It is below one, so we step down before the server could
+// expire our node and elect someone else.
+const SuspendFraction = 2.0 / 3
+
+func (c *Coordinator) suspendTimeout() time.Duration {
+	return time.Duration(float64(c.cfg.SessionTimeout) * SuspendFraction)
+}
+
+// sessionEstablished opens the ready channel of conn if it is current and
+// reports whether the session differs from the previous one, so our
+// candidate node is gone.
+func (c *Coordinator) sessionEstablished(conn *zk.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != conn {
+		return false
+	}
+
+	id := conn.SessionID()
+	renewed := c.session != 0 && c.session != id
+	if renewed {
+		c.node = ""
+	}
+	c.session, c.expired = id, false
+
+	select {
+	case <-c.ready:
+	default:
+		close(c.ready)
+	}
+	return renewed
+}
+
+// sessionSuspended closes the ready channel of conn if it is current.
+func (c *Coordinator) sessionSuspended(conn *zk.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.conn != conn {
+		return
+	}
+
+	select {
+	case <-c.ready:
+		c.ready = make(chan struct{})
+	default:
+	}
+}
+
+// awaitReconnect blocks until the session of conn is back and reports whether
+// it is worth watching again, i.e. conn is still current and not expired.
+func (c *Coordinator) awaitReconnect(conn *zk.Conn, lost chan struct{}) bool {
+	c.mu.Lock()
+	current, ready, expired := c.conn == conn, c.ready, c.expired
+	c.mu.Unlock()
+
+	if !current || expired {
+		return false
+	}
+
+	select {
+	case <-lost:
+		return false
+	case <-ready:
+		return true
+	}
+}
+
+func (c *Coordinator) current(conn *zk.Conn) bool {
+	return c.connection() == conn
+}
+
+// watchNode closes lost once the candidate node that made us the leader is
+// deleted or can no longer be watched.
+func (c *Coordinator) watchNode(conn *zk.Conn, node string, lost chan struct{}, once *sync.Once) {
+	defer once.Do(func() { close(lost) })
+
+	for {
+		exists, _, events, err := conn.ExistsW(node)
+		if err != nil && c.awaitReconnect(conn, lost) {
+			continue
+		}
+		if err != nil || !exists {
+			return
+		}
+
+		select {
+		case <-lost:
+			return
+		case ev := <-events:
+			if ev.Type == zk.EventNodeDeleted || ev.Type == zk.EventNotWatching {
+				return
+			}
+		}
+	}
+}
+
+// ensureCandidate creates the election path with its parents and our
+// sequential ephemeral candidate node under it, unless the node from a
+// previous attempt within the same session is still alive.
+func (c *Coordinator) ensureCandidate(conn *zk.Conn) (string, error) {
+	err := createPath(conn, c.cfg.ElectionPath)
+	if err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	node := c.node
+	c.mu.Unlock()
+
+	if node != "" {
+		exists, _, err := conn.Exists(node)
+		if err != nil {
+			return "", fmt.Errorf("check candidate node %s: %w", node, err)
+		}
+		if exists {
+			return node, nil
+		}
+	}
+
+	data := []byte(fmt.Sprintf("hostname: %s, time: %s", c.cfg.Identity, time.Now()))
+	node, err = conn.Create(path.Join(c.cfg.ElectionPath, candidatePrefix), data, zk.FlagEphemeral|zk.FlagSequence, zk.WorldACL(zk.PermAll))
+	if err != nil {
+		return "", fmt.Errorf("create candidate node: %w", err)
+	}
+
+	c.mu.Lock()
+	c.node = node
+	c.mu.Unlock()
+
+	c.logger.Info("created candidate node", slog.String("node", node))
+	return node, nil
+}
+
+// createPath creates the persistent nodes of p that do not exist yet, from
+// the root down, like mkdir -p.
+func createPath(conn *zk.Conn, p string) error {
+	current := ""
+	for _, part := range strings.Split(strings.Trim(p, "/"), "/") {
+		current += "/" + part
+		_, err := conn.Create(current, nil, 0, zk.WorldACL(zk.PermAll))
+		if err != nil && !errors.Is(err, zk.ErrNodeExists) {
+			return fmt.Errorf("create election path %s: %w", current, err)
+		}
+	}
+	return nil
+}
+
+// campaign blocks until node has the lowest sequence number under the
+// election path. Only the direct predecessor is watched, so a vacated
+// leadership wakes up exactly one follower.
+func (c *Coordinator) campaign(ctx context.Context, conn *zk.Conn, node string) error {
+	var poll <-chan time.Time
+	c.mu.Lock()
+	if c.pollInterval > 0 {
+		ticker := c.cfg.Clock.NewTicker(c.pollInterval)
+		c.pollTicker = ticker
+		defer func() {
+			c.mu.Lock()
+			c.pollTicker = nil
+			c.mu.Unlock()
+			ticker.Stop()
+		}()
+		poll = ticker.Chan()
+	}
+	c.mu.Unlock()
+
+	for {
+		predecessor, err := c.predecessor(conn, node)
+		if err != nil {
+			return err
+		}
+		if predecessor == "" {
+			return nil
+		}
+
+		exists, _, events, err := conn.ExistsW(predecessor)
+		if err != nil {
+			return fmt.Errorf("watch predecessor %s: %w", predecessor, err)
+		}
+		if !exists {
+			continue
+		}
+
+		c.logger.LogAttrs(ctx, slog.LevelInfo, "waiting for predecessor",
+			slog.String("node", node),
+			slog.String("predecessor", predecessor),
+		)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-events:
+		case <-poll:
+		}
+	}
+}
+
+// predecessor returns the full path of the candidate directly preceding node,
+// or an empty string when node is the lowest one.
+func (c *Coordinator) predecessor(conn *zk.Conn, node string) (string, error) {
+	candidates, err := c.candidates(conn)
+	if err != nil {
+		return "", err
+	}
+
+	own := path.Base(node)
+	idx := sort.SearchStrings(candidates, own)
+	if idx == len(candidates) || candidates[idx] != own {
+		return "", fmt.Errorf("candidate node %s is gone", node)
+	}
+	if idx == 0 {
+		return "", nil
+	}
+	return path.Join(c.cfg.ElectionPath, candidates[idx-1]), nil
+}
+
+// candidates returns the candidate node names under the election path in
+// election order.
+func (c *Coordinator) candidates(conn *zk.Conn) ([]string, error) {
+	children, _, err := conn.Children(c.cfg.ElectionPath)
+	if err != nil {
+		return nil, fmt.Errorf("list candidates: %w", err)
+	}
+
+	candidates := make([]string, 0, len(children))
+	for _, child := range children {
+		if strings.HasPrefix(child, candidatePrefix) {
+			candidates = append(candidates, child)
+		}
+	}
+	sort.Strings(candidates)
+	return candidates, nil
+}
@@ -0,0 +1,378 @@
+package zookeeper
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/coordinationtest"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/zookeeper/zktest"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+	"github.com/go-zookeeper/zk"
+)
+
+func newTestServer(t *testing.T) *zktest.Server {
+	t.Helper()
+
+	srv, err := zktest.NewServer()
+	if err != nil {
+		t.Fatalf("start server: %v", err)
+	}
+	t.Cleanup(func() { _ = srv.Close() })
+	return srv
+}
+
+func newTestCoordinator(t *testing.T, srv *zktest.Server, electionPath, identity string) *Coordinator {
+	t.Helper()
+	return newTestCoordinatorWith(t, srv, Config{ElectionPath: electionPath, Identity: identity})
+}
+
+// newTestCoordinatorWith connects a coordinator of cfg to srv.
+func newTestCoordinatorWith(t *testing.T, srv *zktest.Server, cfg Config) *Coordinator {
+	t.Helper()
+
+	cfg.Servers = []string{srv.Addr()}
+	cfg.SessionTimeout = 2 * time.Second
+	c := New(cfg, slog.New(slog.NewTextHandler(io.Discard, nil)))
+	t.Cleanup(func() { _ = c.Close() })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connect %s: %v", cfg.Identity, err)
+	}
+	return c
+}
+
+func acquire(t *testing.T, c *Coordinator) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Acquire(ctx); err != nil {
+		t.Fatalf("acquire %s: %v", c.cfg.Identity, err)
+	}
+}
+
+// acquireAsync campaigns in the background and reports the outcome.
+func acquireAsync(t *testing.T, c *Coordinator) <-chan error {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	t.Cleanup(cancel)
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- c.Acquire(ctx) }()
+	return acquired
+}
+
+func waitLost(t *testing.T, c *Coordinator, why string) {
+	t.Helper()
+
+	select {
+	case <-c.Lost():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("leadership kept after %s", why)
+	}
+}
+
+func waitAcquired(t *testing.T, acquired <-chan error) {
+	t.Helper()
+
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Fatalf("acquire: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("leadership was not taken over")
+	}
+}
+
+func session(c *Coordinator) int64 {
+	return c.connection().SessionID()
+}
+
+func candidateNode(c *Coordinator) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.node
+}
+
+// connect returns a plain client of srv, to change the tree behind the
+// coordinators' backs.
+func connect(t *testing.T, srv *zktest.Server) *zk.Conn {
+	t.Helper()
+
+	conn, events, err := zk.Connect([]string{srv.Addr()}, 2*time.Second, zk.WithLogger(log.New(io.Discard, "", 0)))
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(conn.Close)
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case ev := <-events:
+			if ev.State == zk.StateHasSession {
+				return conn
+			}
+		case <-timeout:
+			t.Fatal("no session")
+		}
+	}
+}
+
+func waitCandidates(t *testing.T, srv *zktest.Server, n int) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		children, _ := srv.Children("/election")
+		if len(children) == n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("candidates %v, want %d", children, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func waitConnected(t *testing.T, c *Coordinator, connected bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for c.Connected() != connected {
+		if time.Now().After(deadline) {
+			t.Fatalf("connected is not %v", connected)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestConformance(t *testing.T) {
+	srv := newTestServer(t)
+	coordinationtest.Run(t, func(t *testing.T, election, identity string) coordination.Coordinator {
+		return newTestCoordinator(t, srv, "/"+election, identity)
+	})
+}
+
+func TestAcquireCreatesNestedElectionPath(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestCoordinator(t, srv, "/services/app/election", "replica")
+
+	acquire(t, c)
+
+	for _, p := range []string{"/services", "/services/app"} {
+		if _, _, err := srv.Get(p); err != nil {
+			t.Fatalf("parent %s: %v", p, err)
+		}
+	}
+	children, err := srv.Children("/services/app/election")
+	if err != nil {
+		t.Fatalf("children: %v", err)
+	}
+	if len(children) != 1 {
+		t.Fatalf("candidates %v, want exactly ours", children)
+	}
+}
+
+func TestPredecessorDeletionWakesOnlyTheNext(t *testing.T) {
+	srv := newTestServer(t)
+	first := newTestCoordinator(t, srv, "/election", "first")
+	second := newTestCoordinator(t, srv, "/election", "second")
+	third := newTestCoordinator(t, srv, "/election", "third")
+
+	acquire(t, first)
+	secondAcquired := acquireAsync(t, second)
+	waitCandidates(t, srv, 2)
+	thirdAcquired := acquireAsync(t, third)
+	waitCandidates(t, srv, 3)
+
+	if err := connect(t, srv).Delete(candidateNode(first), -1); err != nil {
+		t.Fatalf("delete leader node: %v", err)
+	}
+
+	waitLost(t, first, "its node was deleted")
+	waitAcquired(t, secondAcquired)
+	if second.Epoch() <= first.Epoch() {
+		t.Fatalf("epoch %d after takeover, want more than %d", second.Epoch(), first.Epoch())
+	}
+
+	// third watches second, not the deleted node, and keeps waiting.
+	select {
+	case err := <-thirdAcquired:
+		t.Fatalf("third acquired behind a living predecessor: %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestDropKeepsLeadership(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestCoordinator(t, srv, "/election", "replica")
+
+	acquire(t, c)
+	id, node := session(c), candidateNode(c)
+
+	// The server is back well within the suspend timeout.
+	srv.SetAvailable(false)
+	if err := srv.Drop(id); err != nil {
+		t.Fatalf("drop: %v", err)
+	}
+	waitConnected(t, c, false)
+	time.Sleep(c.suspendTimeout() / 4)
+	srv.SetAvailable(true)
+	waitConnected(t, c, true)
+
+	if session(c) != id {
+		t.Fatalf("session %d after reconnect, want %d", session(c), id)
+	}
+	if _, _, err := srv.Get(node); err != nil {
+		t.Fatalf("candidate node after reconnect: %v", err)
+	}
+	select {
+	case <-c.Lost():
+		t.Fatal("leadership lost over a short disconnect")
+	default:
+	}
+}
+
+func TestExpireLosesLeadership(t *testing.T) {
+	srv := newTestServer(t)
+	first := newTestCoordinator(t, srv, "/election", "first")
+	second := newTestCoordinator(t, srv, "/election", "second")
+
+	acquire(t, first)
+	secondAcquired := acquireAsync(t, second)
+	waitCandidates(t, srv, 2)
+	id, node := session(first), candidateNode(first)
+
+	if err := srv.Expire(id); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+
+	waitLost(t, first, "the session expired")
+	if _, _, err := srv.Get(node); !errors.Is(err, zk.ErrNoNode) {
+		t.Fatalf("candidate node of the expired session: %v, want it deleted", err)
+	}
+	waitAcquired(t, secondAcquired)
+	if second.Epoch() <= first.Epoch() {
+		t.Fatalf("epoch %d after takeover, want more than %d", second.Epoch(), first.Epoch())
+	}
+}
+
+func TestRenewedSessionCampaignsAgain(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestCoordinator(t, srv, "/election", "replica")
+
+	acquire(t, c)
+	conn, id, epoch := c.connection(), session(c), c.Epoch()
+
+	// The client replaces an expired session by itself, Connect keeps the
+	// connection and only waits for the new session.
+	if err := srv.Expire(id); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	waitLost(t, c, "the session expired")
+	waitConnected(t, c, true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	if c.connection() != conn {
+		t.Fatal("reconnected instead of waiting for the renewed session")
+	}
+	if session(c) == id {
+		t.Fatal("the expired session is still in use")
+	}
+
+	acquire(t, c)
+	if c.Epoch() <= epoch {
+		t.Fatalf("epoch %d in the new session, want more than %d", c.Epoch(), epoch)
+	}
+	if children, _ := srv.Children("/election"); len(children) != 1 {
+		t.Fatalf("candidates %v, want exactly the new one", children)
+	}
+}
+
+func TestReleaseKeepsNodeOfAnotherSession(t *testing.T) {
+	srv := newTestServer(t)
+	c := newTestCoordinator(t, srv, "/election", "replica")
+
+	acquire(t, c)
+	node := candidateNode(c)
+	if err := srv.Expire(session(c)); err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	waitLost(t, c, "the session expired")
+
+	// Release can run before the expiry reaches the coordinator, while it
+	// still names a node that another session has created since.
+	other := connect(t, srv)
+	if _, err := other.Create(node, nil, zk.FlagEphemeral, zk.WorldACL(zk.PermAll)); err != nil {
+		t.Fatalf("create node of another session: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := c.Connect(ctx); err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	c.mu.Lock()
+	c.node = node
+	c.mu.Unlock()
+
+	if err := c.Release(context.Background()); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	if _, stat, err := srv.Get(node); err != nil || stat.EphemeralOwner != other.SessionID() {
+		t.Fatalf("node of another session after release: %v, want it kept", err)
+	}
+}
+
+func TestCampaignPollsOnClock(t *testing.T) {
+	srv := newTestServer(t)
+	clock := extra.NewFakeClock(time.Unix(0, 0))
+	first := newTestCoordinator(t, srv, "/election", "first")
+	second := newTestCoordinatorWith(t, srv, Config{
+		ElectionPath: "/election",
+		Identity:     "second",
+		PollInterval: time.Minute,
+		Clock:        clock,
+	})
+
+	acquire(t, first)
+	acquired := acquireAsync(t, second)
+
+	waiting := make(chan struct{})
+	go func() {
+		defer close(waiting)
+		clock.BlockUntil(1)
+	}()
+	select {
+	case <-waiting:
+	case <-time.After(5 * time.Second):
+		t.Fatal("campaign does not poll on the clock")
+	}
+
+	armed := clock.Armed()
+	clock.Advance(time.Minute)
+	if clock.Armed() == armed {
+		t.Fatal("poll ticker did not fire on the clock")
+	}
+
+	if err := first.Release(context.Background()); err != nil {
+		t.Fatalf("release: %v", err)
+	}
+	waitAcquired(t, acquired)
+	if waiters := clock.Waiters(); waiters != 0 {
+		t.Fatalf("%d tickers left after the campaign", waiters)
+	}
+}
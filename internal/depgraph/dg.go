@@ -4,11 +4,28 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"sync"
+	"time"
 
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/etcd"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/filelock"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/kubernetes"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/postgres"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/raft"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/redis"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/zookeeper"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/admin"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/health"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/hooks"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/reload"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/states"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/tasks"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 type dgEntity[T any] struct {
@@ -29,32 +46,302 @@ func (e *dgEntity[T]) get(init func() (T, error)) (T, error) {
 
 type DepGraph struct {
 	logger         *dgEntity[*slog.Logger]
+	clock          *dgEntity[extra.Clock]
+	registry       *dgEntity[prometheus.Registerer]
+	coordinator    *dgEntity[coordination.Coordinator]
 	stateRunner    *dgEntity[*run.LoopRunner]
 	initState      *dgEntity[*states.InitState]
 	attempterState *dgEntity[*states.AttempterState]
 	leaderState    *dgEntity[*states.LeaderState]
 	failoverState  *dgEntity[*states.FailoverState]
 	stoppingState  *dgEntity[*states.StoppingState]
+	maintenance    *dgEntity[*states.MaintenanceState]
+	leaderTasks    *dgEntity[[]tasks.LeaderTask]
+	hookDispatcher *dgEntity[*hooks.Dispatcher]
+	adminAPI       *dgEntity[*admin.API]
+	probes         *dgEntity[*health.Probes]
+	reloader       *dgEntity[*reload.Reloader]
+
+	// extraTasks are run by the leader in addition to the configured ones.
+	extraTasks []tasks.LeaderTask
 }
 
 func New() *DepGraph {
 	return &DepGraph{
 		logger:         &dgEntity[*slog.Logger]{},
+		clock:          &dgEntity[extra.Clock]{},
+		registry:       &dgEntity[prometheus.Registerer]{},
+		coordinator:    &dgEntity[coordination.Coordinator]{},
 		stateRunner:    &dgEntity[*run.LoopRunner]{},
 		initState:      &dgEntity[*states.InitState]{},
 		attempterState: &dgEntity[*states.AttempterState]{},
 		leaderState:    &dgEntity[*states.LeaderState]{},
 		failoverState:  &dgEntity[*states.FailoverState]{},
 		stoppingState:  &dgEntity[*states.StoppingState]{},
+		maintenance:    &dgEntity[*states.MaintenanceState]{},
+		leaderTasks:    &dgEntity[[]tasks.LeaderTask]{},
+		hookDispatcher: &dgEntity[*hooks.Dispatcher]{},
+		adminAPI:       &dgEntity[*admin.API]{},
+		probes:         &dgEntity[*health.Probes]{},
+		reloader:       &dgEntity[*reload.Reloader]{},
 	}
 }
 
+// WithLogger makes the graph use logger instead of the default stdout one.
+// It has no effect once the logger has been requested.
+func (dg *DepGraph) WithLogger(logger *slog.Logger) *DepGraph {
+	_, _ = dg.logger.get(func() (*slog.Logger, error) {
+		return logger, nil
+	})
+	return dg
+}
+
+// WithClock makes the graph use clock instead of the real one. It has no
+// effect once the clock has been requested.
+func (dg *DepGraph) WithClock(clock extra.Clock) *DepGraph {
+	_, _ = dg.clock.get(func() (extra.Clock, error) {
+		return clock, nil
+	})
+	return dg
+}
+
+// WithRegistry makes the graph keep its metrics in registry instead of the
+// global one. It has no effect once the registry has been requested.
+func (dg *DepGraph) WithRegistry(registry prometheus.Registerer) *DepGraph {
+	_, _ = dg.registry.get(func() (prometheus.Registerer, error) {
+		return registry, nil
+	})
+	return dg
+}
+
+// WithCoordinator makes the graph use coordinator whatever the backend is.
+// It has no effect once the coordinator has been requested.
+func (dg *DepGraph) WithCoordinator(coordinator coordination.Coordinator) *DepGraph {
+	_, _ = dg.coordinator.get(func() (coordination.Coordinator, error) {
+		return coordinator, nil
+	})
+	return dg
+}
+
+// WithRunner makes the graph use runner instead of one on the real clock. It
+// has no effect once the runner has been requested.
+func (dg *DepGraph) WithRunner(runner *run.LoopRunner) *DepGraph {
+	_, _ = dg.stateRunner.get(func() (*run.LoopRunner, error) {
+		return runner, nil
+	})
+	return dg
+}
+
+// WithLeaderTasks makes the leader run leaderTasks next to the configured
+// ones. It has no effect once the leader tasks have been requested.
+func (dg *DepGraph) WithLeaderTasks(leaderTasks ...tasks.LeaderTask) *DepGraph {
+	dg.extraTasks = append(dg.extraTasks, leaderTasks...)
+	return dg
+}
+
 func (dg *DepGraph) GetLogger() (*slog.Logger, error) {
 	return dg.logger.get(func() (*slog.Logger, error) {
 		return slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{})), nil
 	})
 }
 
+func (dg *DepGraph) GetClock() (extra.Clock, error) {
+	return dg.clock.get(func() (extra.Clock, error) {
+		return extra.NewClock(), nil
+	})
+}
+
+// GetRegistry returns the global registry unless WithRegistry set another.
+func (dg *DepGraph) GetRegistry() (prometheus.Registerer, error) {
+	return dg.registry.get(func() (prometheus.Registerer, error) {
+		return prometheus.DefaultRegisterer, nil
+	})
+}
+
+func (dg *DepGraph) GetCoordinator(args cmdargs.RunArgs) (coordination.Coordinator, error) {
+	return dg.coordinator.get(func() (coordination.Coordinator, error) {
+		logger, err := dg.GetLogger()
+		if err != nil {
+			return nil, fmt.Errorf("get logger: %w", err)
+		}
+
+		clock, err := dg.GetClock()
+		if err != nil {
+			return nil, fmt.Errorf("get clock: %w", err)
+		}
+
+		switch args.Backend {
+		case cmdargs.BackendZookeeper:
+			var pollInterval time.Duration
+			if args.AttempterPolling {
+				pollInterval = args.AttempterTimeout
+			}
+
+			return zookeeper.New(zookeeper.Config{
+				Servers:        args.ZkServers,
+				SessionTimeout: args.SessionTimeout,
+				ElectionPath:   args.ZKEphemeralPath,
+				PollInterval:   pollInterval,
+				Identity:       Identity(args),
+				Clock:          clock,
+			}, logger), nil
+
+		case cmdargs.BackendEtcd:
+			return etcd.New(etcd.Config{
+				Endpoints:      args.EtcdEndpoints,
+				SessionTimeout: args.SessionTimeout,
+				ElectionPath:   args.ZKEphemeralPath,
+				Identity:       Identity(args),
+			}, logger), nil
+
+		case cmdargs.BackendKubernetes:
+			return kubernetes.New(kubernetes.Config{
+				Namespace:     args.K8sNamespace,
+				LeaseName:     args.K8sLeaseName,
+				Identity:      Identity(args),
+				LeaseDuration: args.LeaseDuration,
+				RenewDeadline: args.RenewDeadline,
+				RetryPeriod:   args.RetryPeriod,
+			}, logger), nil
+
+		case cmdargs.BackendPostgres:
+			return postgres.New(postgres.Config{
+				DSN:          args.PgDSN,
+				Mode:         args.PgMode,
+				ElectionPath: args.ZKEphemeralPath,
+				Table:        args.PgTable,
+				Identity:     Identity(args),
+				LeaseTTL:     args.LeaseDuration,
+				RetryPeriod:  args.RetryPeriod,
+			}, logger), nil
+
+		case cmdargs.BackendRedis:
+			return redis.New(redis.Config{
+				Addr:         args.RedisAddr,
+				ElectionPath: args.ZKEphemeralPath,
+				Identity:     Identity(args),
+				TTL:          args.LeaseDuration,
+			}, logger), nil
+
+		case cmdargs.BackendRaft:
+			peers := args.RaftPeers
+			if len(peers) == 0 {
+				peers = []string{args.RaftBind}
+			}
+
+			return raft.New(raft.Config{
+				Bind:           args.RaftBind,
+				Identity:       Identity(args),
+				Peers:          peers,
+				SessionTimeout: args.SessionTimeout,
+			}, logger), nil
+
+		case cmdargs.BackendFileLock:
+			lockFile := args.LockFile
+			if lockFile == "" {
+				lockFile = filepath.Clean(args.FileDir) + ".lock"
+			}
+
+			return filelock.New(filelock.Config{
+				Path:        lockFile,
+				Identity:    Identity(args),
+				RetryPeriod: args.RetryPeriod,
+			}, logger), nil
+
+		default:
+			return nil, fmt.Errorf("unknown backend %q", args.Backend)
+		}
+	})
+}
+
+func (dg *DepGraph) GetLeaderTasks(args cmdargs.RunArgs) ([]tasks.LeaderTask, error) {
+	return dg.leaderTasks.get(func() ([]tasks.LeaderTask, error) {
+		logger, err := dg.GetLogger()
+		if err != nil {
+			return nil, fmt.Errorf("get logger: %w", err)
+		}
+
+		clock, err := dg.GetClock()
+		if err != nil {
+			return nil, fmt.Errorf("get clock: %w", err)
+		}
+
+		var leaderTasks []tasks.LeaderTask
+		for _, name := range args.LeaderTasks {
+			switch name {
+			case cmdargs.LeaderTaskFile:
+				leaderTasks = append(leaderTasks, tasks.NewFileWriter(args.FileDir, args.StorageCapacity, args.LeaderTimeout, clock, logger))
+			case cmdargs.LeaderTaskExec:
+				if len(args.Command) == 0 {
+					return nil, fmt.Errorf("leader task %q needs a command", name)
+				}
+				leaderTasks = append(leaderTasks, tasks.NewExec(args.Command, args.ExecGracePeriod, args.ExecRestartDelay, clock, logger))
+			default:
+				return nil, fmt.Errorf("unknown leader task %q", name)
+			}
+		}
+		return append(leaderTasks, dg.extraTasks...), nil
+	})
+}
+
+func (dg *DepGraph) GetHookDispatcher(args cmdargs.RunArgs) (*hooks.Dispatcher, error) {
+	return dg.hookDispatcher.get(func() (*hooks.Dispatcher, error) {
+		logger, err := dg.GetLogger()
+		if err != nil {
+			return nil, fmt.Errorf("get logger: %w", err)
+		}
+
+		coordinator, err := dg.GetCoordinator(args)
+		if err != nil {
+			return nil, fmt.Errorf("get coordinator: %w", err)
+		}
+
+		clock, err := dg.GetClock()
+		if err != nil {
+			return nil, fmt.Errorf("get clock: %w", err)
+		}
+
+		dispatcher := hooks.NewDispatcher(args.HookTimeout, args.HookRetries, coordinator.Epoch, clock, logger)
+		leaderState := "LeaderState"
+		if args.OnLeader != "" {
+			dispatcher.Add(hooks.NewScript(args.OnLeader), hooks.OnEnter(leaderState))
+		}
+		if args.OnFollower != "" {
+			dispatcher.Add(hooks.NewScript(args.OnFollower), hooks.OnFollow(leaderState, "AttempterState"))
+		}
+		for _, spec := range args.OnEnter {
+			state, script, _ := cmdargs.SplitStateHook(spec)
+			dispatcher.Add(hooks.NewScript(script), hooks.OnEnter(state))
+		}
+		for _, spec := range args.OnLeave {
+			state, script, _ := cmdargs.SplitStateHook(spec)
+			dispatcher.Add(hooks.NewScript(script), hooks.OnLeave(state))
+		}
+		for _, url := range args.Webhooks {
+			dispatcher.Add(hooks.NewWebhook(url), hooks.Always)
+		}
+		return dispatcher, nil
+	})
+}
+
+func (dg *DepGraph) GetAdminAPI(args cmdargs.RunArgs) (*admin.API, error) {
+	return dg.adminAPI.get(func() (*admin.API, error) {
+		return admin.NewAPI(args, dg)
+	})
+}
+
+func (dg *DepGraph) GetProbes(args cmdargs.RunArgs) (*health.Probes, error) {
+	return dg.probes.get(func() (*health.Probes, error) {
+		return health.NewProbes(args, dg)
+	})
+}
+
+func (dg *DepGraph) GetReloader(args cmdargs.RunArgs) (*reload.Reloader, error) {
+	return dg.reloader.get(func() (*reload.Reloader, error) {
+		return reload.NewReloader(args, dg)
+	})
+}
+
 func (dg *DepGraph) GetInitState(args cmdargs.RunArgs) (*states.InitState, error) {
 	return dg.initState.get(func() (*states.InitState, error) {
 		return states.NewInitState(args, dg)
@@ -85,12 +372,34 @@ func (dg *DepGraph) GetStoppingState(args cmdargs.RunArgs) (*states.StoppingStat
 	})
 }
 
+func (dg *DepGraph) GetMaintenanceState(args cmdargs.RunArgs) (*states.MaintenanceState, error) {
+	return dg.maintenance.get(func() (*states.MaintenanceState, error) {
+		return states.NewMaintenanceState(args, dg)
+	})
+}
+
 func (dg *DepGraph) GetRunner() (run.Runner, error) {
 	return dg.stateRunner.get(func() (*run.LoopRunner, error) {
 		logger, err := dg.GetLogger()
 		if err != nil {
 			return nil, fmt.Errorf("get logger: %w", err)
 		}
-		return run.NewLoopRunner(logger), nil
+
+		clock, err := dg.GetClock()
+		if err != nil {
+			return nil, fmt.Errorf("get clock: %w", err)
+		}
+
+		registry, err := dg.GetRegistry()
+		if err != nil {
+			return nil, fmt.Errorf("get registry: %w", err)
+		}
+		return run.NewLoopRunner(logger, clock, registry), nil
 	})
 }
+
+// Identity returns the name this replica campaigns under: the configured
+// identity, else the pod name.
+func Identity(args cmdargs.RunArgs) string {
+	return extra.Identity(args.Identity)
+}
@@ -0,0 +1,188 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/states"
+)
+
+type DepGraph interface {
+	GetLogger() (*slog.Logger, error)
+	GetClock() (extra.Clock, error)
+	GetRunner() (run.Runner, error)
+	GetCoordinator(args cmdargs.RunArgs) (coordination.Coordinator, error)
+	GetLeaderState(args cmdargs.RunArgs) (*states.LeaderState, error)
+	GetMaintenanceState(args cmdargs.RunArgs) (*states.MaintenanceState, error)
+}
+
+// Mux is where the API registers its routes, run.Runner is one.
+type Mux interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// Status is the response of GET /status.
+type Status struct {
+	State              string  `json:"state"`
+	TimeInStateSeconds float64 `json:"time_in_state_seconds"`
+	Identity           string  `json:"identity"`
+	Leader             string  `json:"leader"`
+	LeaderError        string  `json:"leader_error,omitempty"`
+	Epoch              int64   `json:"epoch"`
+	Paused             bool    `json:"paused"`
+}
+
+func NewAPI(args cmdargs.RunArgs, dg DepGraph) (*API, error) {
+	logger, err := dg.GetLogger()
+	if err != nil {
+		return nil, fmt.Errorf("get logger: %w", err)
+	}
+
+	clock, err := dg.GetClock()
+	if err != nil {
+		return nil, fmt.Errorf("get clock: %w", err)
+	}
+
+	runner, err := dg.GetRunner()
+	if err != nil {
+		return nil, fmt.Errorf("get runner: %w", err)
+	}
+
+	coordinator, err := dg.GetCoordinator(args)
+	if err != nil {
+		return nil, fmt.Errorf("get coordinator: %w", err)
+	}
+
+	leaderState, err := dg.GetLeaderState(args)
+	if err != nil {
+		return nil, fmt.Errorf("get leader state: %w", err)
+	}
+
+	maintenance, err := dg.GetMaintenanceState(args)
+	if err != nil {
+		return nil, fmt.Errorf("get maintenance state: %w", err)
+	}
+
+	return &API{
+		logger:      logger.With("subsystem", "AdminAPI"),
+		token:       args.AdminToken,
+		identity:    extra.Identity(args.Identity),
+		clock:       clock,
+		runner:      runner,
+		coordinator: coordinator,
+		leaderState: leaderState,
+		maintenance: maintenance,
+	}, nil
+}
+
+// API lets operators inspect and steer a running node over HTTP. With a
+// token set every request must carry it as a bearer token.
+type API struct {
+	logger      *slog.Logger
+	token       string
+	identity    string
+	clock       extra.Clock
+	runner      run.Runner
+	coordinator coordination.Coordinator
+	leaderState *states.LeaderState
+	maintenance *states.MaintenanceState
+}
+
+// Mount registers the API routes on mux.
+func (a *API) Mount(mux Mux) {
+	mux.Handle("GET /status", a.auth(a.status))
+	mux.Handle("POST /resign", a.auth(a.resign))
+	mux.Handle("POST /pause", a.auth(a.pause))
+	mux.Handle("POST /resume", a.auth(a.resume))
+}
+
+func (a *API) status(w http.ResponseWriter, r *http.Request) {
+	state, since := a.runner.Current()
+
+	status := Status{
+		State:    state,
+		Identity: a.identity,
+		Epoch:    a.coordinator.Epoch(),
+		Paused:   isClosed(a.maintenance.Paused()),
+	}
+	if !since.IsZero() {
+		status.TimeInStateSeconds = a.clock.Now().Sub(since).Seconds()
+	}
+
+	if a.coordinator.Connected() {
+		leader, err := a.coordinator.Leader(r.Context())
+		if err != nil {
+			status.LeaderError = err.Error()
+		}
+		status.Leader = leader
+	} else {
+		status.LeaderError = coordination.ErrNotConnected.Error()
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (a *API) resign(w http.ResponseWriter, r *http.Request) {
+	state, _ := a.runner.Current()
+	if state != a.leaderState.String() {
+		writeError(w, http.StatusConflict, "not the leader")
+		return
+	}
+
+	a.logger.LogAttrs(r.Context(), slog.LevelInfo, "resign requested", slog.String("remote", r.RemoteAddr))
+	a.leaderState.Resign()
+	writeJSON(w, http.StatusAccepted, map[string]string{"result": "resigning"})
+}
+
+func (a *API) pause(w http.ResponseWriter, r *http.Request) {
+	a.logger.LogAttrs(r.Context(), slog.LevelInfo, "pause requested", slog.String("remote", r.RemoteAddr))
+	a.maintenance.Pause()
+	writeJSON(w, http.StatusAccepted, map[string]bool{"paused": true})
+}
+
+func (a *API) resume(w http.ResponseWriter, r *http.Request) {
+	a.logger.LogAttrs(r.Context(), slog.LevelInfo, "resume requested", slog.String("remote", r.RemoteAddr))
+	a.maintenance.Resume()
+	writeJSON(w, http.StatusAccepted, map[string]bool{"paused": false})
+}
+
+func (a *API) auth(next http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if a.token != "" {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) != 1 {
+				w.Header().Set("WWW-Authenticate", "Bearer")
+				writeError(w, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+		}
+		next(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, code int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(body)
+}
+
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, map[string]string{"error": msg})
+}
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
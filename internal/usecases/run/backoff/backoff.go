@@ -0,0 +1,148 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+const (
+	StrategyConstant     = "constant"
+	StrategyExponential  = "exponential"
+	StrategyDecorrelated = "decorrelated"
+)
+
+// defaultInitial is used when Config.Initial is not set.
+const defaultInitial = time.Second
+
+// ErrExhausted is returned by Wait once the retry or elapsed time limit is hit.
+var ErrExhausted = errors.New("backoff exhausted")
+
+// Config describes a backoff policy. Zero Max, MaxElapsed and MaxRetries mean
+// no limit, so the zero Config retries forever every second with doubling
+// delays.
+type Config struct {
+	Strategy   string
+	Initial    time.Duration
+	Max        time.Duration
+	MaxElapsed time.Duration
+	MaxRetries int
+}
+
+// Clock is the part of the clock Backoff waits with.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// Backoff yields the delays between retries of a single operation, create a
+// new one for every operation.
+type Backoff struct {
+	cfg   Config
+	clock Clock
+	rand  *rand.Rand
+
+	attempt int
+	prev    time.Duration
+	start   time.Time
+}
+
+// New returns a Backoff driven by clock and, for the decorrelated strategy,
+// by rnd. A nil clock means real time, a nil rnd means the global source.
+func New(cfg Config, clock Clock, rnd *rand.Rand) *Backoff {
+	if cfg.Initial <= 0 {
+		cfg.Initial = defaultInitial
+	}
+	if clock == nil {
+		clock = realClock{}
+	}
+
+	return &Backoff{
+		cfg:   cfg,
+		clock: clock,
+		rand:  rnd,
+	}
+}
+
+// Attempt returns how many delays have been handed out so far.
+func (b *Backoff) Attempt() int {
+	return b.attempt
+}
+
+// Next returns the next delay and false once the policy is exhausted.
+func (b *Backoff) Next() (time.Duration, bool) {
+	now := b.clock.Now()
+	if b.start.IsZero() {
+		b.start = now
+	}
+
+	if b.cfg.MaxRetries > 0 && b.attempt >= b.cfg.MaxRetries {
+		return 0, false
+	}
+
+	var delay time.Duration
+	switch b.cfg.Strategy {
+	case StrategyConstant:
+		delay = b.cfg.Initial
+	case StrategyDecorrelated:
+		// See "Exponential Backoff And Jitter" on the AWS Architecture Blog:
+		// a random delay between the initial one and three times the previous.
+		prev := min(max(b.prev, b.cfg.Initial), math.MaxInt64/4)
+		delay = b.cfg.Initial + time.Duration(b.int64N(int64(3*prev-b.cfg.Initial)+1))
+	default:
+		delay = b.cfg.Initial
+		if b.prev > 0 {
+			delay = 2 * b.prev
+		}
+	}
+	if delay <= 0 {
+		// The doubling overflowed.
+		delay = b.prev
+	}
+	if b.cfg.Max > 0 && delay > b.cfg.Max {
+		delay = b.cfg.Max
+	}
+
+	if b.cfg.MaxElapsed > 0 {
+		left := b.cfg.MaxElapsed - now.Sub(b.start)
+		if left <= 0 {
+			return 0, false
+		}
+		delay = min(delay, left)
+	}
+
+	b.attempt++
+	b.prev = delay
+	return delay, true
+}
+
+// Wait sleeps for the next delay. It returns ErrExhausted when there is none
+// and the context error when ctx is done first.
+func (b *Backoff) Wait(ctx context.Context) error {
+	delay, ok := b.Next()
+	if !ok {
+		return ErrExhausted
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.clock.After(delay):
+		return nil
+	}
+}
+
+func (b *Backoff) int64N(n int64) int64 {
+	if b.rand != nil {
+		return b.rand.Int64N(n)
+	}
+	return rand.Int64N(n)
+}
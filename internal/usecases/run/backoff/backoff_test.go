@@ -0,0 +1,126 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"testing"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+)
+
+func delays(b *Backoff) []time.Duration {
+	var got []time.Duration
+	for len(got) < 100 {
+		delay, ok := b.Next()
+		if !ok {
+			break
+		}
+		got = append(got, delay)
+	}
+	return got
+}
+
+func TestNext(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+		want []time.Duration
+	}{
+		{
+			name: "constant",
+			cfg:  Config{Strategy: StrategyConstant, Initial: time.Second, MaxRetries: 3},
+			want: []time.Duration{time.Second, time.Second, time.Second},
+		},
+		{
+			name: "exponential capped by max",
+			cfg:  Config{Strategy: StrategyExponential, Initial: time.Second, Max: 5 * time.Second, MaxRetries: 5},
+			want: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second, 5 * time.Second},
+		},
+		{
+			name: "zero config doubles from a second",
+			cfg:  Config{MaxRetries: 3},
+			want: []time.Duration{time.Second, 2 * time.Second, 4 * time.Second},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := delays(New(tt.cfg, extra.NewFakeClock(time.Unix(0, 0)), nil))
+			if len(got) != len(tt.want) {
+				t.Fatalf("got delays %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("delay %d is %v, want %v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestDecorrelatedStaysInBounds(t *testing.T) {
+	cfg := Config{Strategy: StrategyDecorrelated, Initial: 100 * time.Millisecond, Max: 10 * time.Second, MaxRetries: 100}
+	b := New(cfg, extra.NewFakeClock(time.Unix(0, 0)), rand.New(rand.NewPCG(1, 2)))
+
+	prev := cfg.Initial
+	for i, delay := range delays(b) {
+		upper := min(3*prev, cfg.Max)
+		if delay < cfg.Initial || delay > upper {
+			t.Fatalf("delay %d is %v, want within [%v, %v]", i, delay, cfg.Initial, upper)
+		}
+		prev = delay
+	}
+}
+
+func TestMaxElapsedFollowsClock(t *testing.T) {
+	clock := extra.NewFakeClock(time.Unix(0, 0))
+	b := New(Config{Strategy: StrategyConstant, Initial: 4 * time.Second, MaxElapsed: 10 * time.Second}, clock, nil)
+
+	for _, want := range []time.Duration{4 * time.Second, 4 * time.Second, 2 * time.Second} {
+		delay, ok := b.Next()
+		if !ok || delay != want {
+			t.Fatalf("got %v, %v, want %v", delay, ok, want)
+		}
+		clock.Advance(delay)
+	}
+	if delay, ok := b.Next(); ok {
+		t.Fatalf("got %v past MaxElapsed, want exhausted", delay)
+	}
+}
+
+func TestWait(t *testing.T) {
+	clock := extra.NewFakeClock(time.Unix(0, 0))
+	b := New(Config{Strategy: StrategyConstant, Initial: time.Second, MaxRetries: 1}, clock, nil)
+
+	waited := make(chan error, 1)
+	go func() { waited <- b.Wait(context.Background()) }()
+
+	clock.BlockUntil(1)
+	select {
+	case err := <-waited:
+		t.Fatalf("wait returned before the delay passed: %v", err)
+	default:
+	}
+
+	clock.Advance(time.Second)
+	if err := <-waited; err != nil {
+		t.Fatalf("wait: %v", err)
+	}
+	if b.Attempt() != 1 {
+		t.Fatalf("attempt is %d, want 1", b.Attempt())
+	}
+	if err := b.Wait(context.Background()); !errors.Is(err, ErrExhausted) {
+		t.Fatalf("wait past MaxRetries returned %v, want ErrExhausted", err)
+	}
+}
+
+func TestWaitCancelled(t *testing.T) {
+	b := New(Config{Initial: time.Hour}, extra.NewFakeClock(time.Unix(0, 0)), nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := b.Wait(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("wait returned %v, want context.Canceled", err)
+	}
+}
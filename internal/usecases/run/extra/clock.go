@@ -0,0 +1,64 @@
+package extra
+
+import "time"
+
+// Clock is the source of time of the states, so that tests can replace the
+// real one with a FakeClock.
+type Clock interface {
+	Now() time.Time
+	NewTicker(d time.Duration) Ticker
+	NewTimer(d time.Duration) Timer
+	After(d time.Duration) <-chan time.Time
+	Sleep(d time.Duration)
+}
+
+type Timer interface {
+	Chan() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// NewClock returns the real clock.
+func NewClock() Clock {
+	return realClock{}
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTicker(d time.Duration) Ticker {
+	return NewTicker(d)
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return &timerWrapper{
+		timer: time.NewTimer(d),
+	}
+}
+
+func (realClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+func (realClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
+
+type timerWrapper struct {
+	timer *time.Timer
+}
+
+func (tw *timerWrapper) Chan() <-chan time.Time {
+	return tw.timer.C
+}
+
+func (tw *timerWrapper) Stop() bool {
+	return tw.timer.Stop()
+}
+
+func (tw *timerWrapper) Reset(d time.Duration) bool {
+	return tw.timer.Reset(d)
+}
@@ -0,0 +1,198 @@
+package extra
+
+import (
+	"sync"
+	"time"
+)
+
+// FakeClock is a Clock that only moves when Advance is called. Timers and
+// tickers due within an Advance fire in order of their deadlines, each seeing
+// Now at its own deadline, so a scenario steps deterministically through
+// simulated time.
+type FakeClock struct {
+	mu      sync.Mutex
+	cond    *sync.Cond
+	now     time.Time
+	seq     uint64
+	waiters map[*fakeWaiter]struct{}
+}
+
+func NewFakeClock(now time.Time) *FakeClock {
+	c := &FakeClock{
+		now:     now,
+		waiters: make(map[*fakeWaiter]struct{}),
+	}
+	c.cond = sync.NewCond(&c.mu)
+	return c
+}
+
+// fakeWaiter is a pending timer or, with a positive period, a ticker.
+type fakeWaiter struct {
+	clock  *FakeClock
+	ch     chan time.Time
+	when   time.Time
+	period time.Duration
+	seq    uint64
+}
+
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *FakeClock) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock.NewTicker")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &fakeWaiter{clock: c, ch: make(chan time.Time, 1), period: d}
+	c.schedule(w, d)
+	return fakeTicker{w}
+}
+
+func (c *FakeClock) NewTimer(d time.Duration) Timer {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	w := &fakeWaiter{clock: c, ch: make(chan time.Time, 1)}
+	c.schedule(w, d)
+	c.fire(c.now)
+	return fakeTimer{w}
+}
+
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	return c.NewTimer(d).Chan()
+}
+
+// Sleep blocks until another goroutine advances the clock by d.
+func (c *FakeClock) Sleep(d time.Duration) {
+	<-c.After(d)
+}
+
+// Advance moves the clock forward by d firing every timer and ticker due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fire(c.now.Add(d))
+}
+
+// Waiters returns the number of pending timers and tickers.
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.waiters)
+}
+
+// Armed returns how many times timers and tickers have been armed so far. A
+// driver compares it between looks to tell whether the code under test still
+// works with the clock.
+func (c *FakeClock) Armed() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.seq
+}
+
+// BlockUntil blocks until at least n timers and tickers are pending, i.e.
+// until the code under test is waiting on the clock.
+func (c *FakeClock) BlockUntil(n int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for len(c.waiters) < n {
+		c.cond.Wait()
+	}
+}
+
+// schedule (re)arms w to fire after d, c.mu must be held.
+func (c *FakeClock) schedule(w *fakeWaiter, d time.Duration) {
+	c.seq++
+	w.when, w.seq = c.now.Add(d), c.seq
+	c.waiters[w] = struct{}{}
+	c.cond.Broadcast()
+}
+
+// fire moves the clock to until, firing waiters due on the way in order of
+// their deadlines. c.mu must be held.
+func (c *FakeClock) fire(until time.Time) {
+	for {
+		var next *fakeWaiter
+		for w := range c.waiters {
+			if w.when.After(until) {
+				continue
+			}
+			if next == nil || w.when.Before(next.when) || (w.when.Equal(next.when) && w.seq < next.seq) {
+				next = w
+			}
+		}
+		if next == nil {
+			break
+		}
+
+		if next.when.After(c.now) {
+			c.now = next.when
+		}
+		select {
+		case next.ch <- c.now:
+		default:
+			// Like time.Ticker, drop the tick nobody has read yet.
+		}
+
+		if next.period > 0 {
+			c.schedule(next, next.period)
+		} else {
+			delete(c.waiters, next)
+		}
+	}
+
+	if until.After(c.now) {
+		c.now = until
+	}
+}
+
+type fakeTimer struct{ *fakeWaiter }
+
+func (t fakeTimer) Chan() <-chan time.Time { return t.ch }
+
+func (t fakeTimer) Stop() bool { return t.stop() }
+
+func (t fakeTimer) Reset(d time.Duration) bool { return t.reset(d) }
+
+type fakeTicker struct{ *fakeWaiter }
+
+func (t fakeTicker) Chan() <-chan time.Time { return t.ch }
+
+func (t fakeTicker) Stop() { t.stop() }
+
+func (t fakeTicker) Reset(d time.Duration) {
+	if d <= 0 {
+		panic("non-positive interval for FakeClock ticker Reset")
+	}
+	t.reset(d)
+}
+
+func (w *fakeWaiter) stop() bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	_, active := w.clock.waiters[w]
+	delete(w.clock.waiters, w)
+	return active
+}
+
+func (w *fakeWaiter) reset(d time.Duration) bool {
+	w.clock.mu.Lock()
+	defer w.clock.mu.Unlock()
+
+	_, active := w.clock.waiters[w]
+	if w.period > 0 {
+		w.period = d
+	}
+	w.clock.schedule(w, d)
+	w.clock.fire(w.clock.now)
+	return active
+}
@@ -0,0 +1,25 @@
+package extra
+
+import "os"
+
+// Hostname returns the host name reported by the kernel or "unknown".
+func Hostname() string {
+	host, err := os.Hostname()
+	if err != nil {
+		return "unknown"
+	}
+	return host
+}
+
+// Identity returns the name a replica campaigns under: configured when set,
+// else the pod name exposed through the downward API as POD_NAME, else the
+// hostname, which kubernetes sets to the pod name too.
+func Identity(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	return Hostname()
+}
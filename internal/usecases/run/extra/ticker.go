@@ -5,6 +5,7 @@ import "time"
 type Ticker interface {
 	Chan() <-chan time.Time
 	Stop()
+	Reset(d time.Duration)
 }
 
 type tickerWrapper struct {
@@ -24,3 +25,7 @@ func (tw *tickerWrapper) Chan() <-chan time.Time {
 func (tw *tickerWrapper) Stop() {
 	tw.ticker.Stop()
 }
+
+func (tw *tickerWrapper) Reset(d time.Duration) {
+	tw.ticker.Reset(d)
+}
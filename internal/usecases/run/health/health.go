@@ -0,0 +1,177 @@
+package health
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+)
+
+const (
+	// ReadyConnected makes /readyz succeed on every node connected to the
+	// coordinator.
+	ReadyConnected = "connected"
+	// ReadyLeader makes /readyz succeed on the leader only.
+	ReadyLeader = "leader"
+)
+
+const (
+	leaderState   = "LeaderState"
+	failoverState = "FailoverState"
+	stoppingState = "StoppingState"
+)
+
+// transient are the states the machine is expected to pass through quickly.
+var transient = map[string]bool{
+	"InitState":   true,
+	stoppingState: true,
+}
+
+type DepGraph interface {
+	GetClock() (extra.Clock, error)
+	GetRunner() (run.Runner, error)
+	GetCoordinator(args cmdargs.RunArgs) (coordination.Coordinator, error)
+}
+
+// Mux is where the probes register their routes, run.Runner is one.
+type Mux interface {
+	Handle(pattern string, handler http.Handler)
+}
+
+// Report is the body of every probe response.
+type Report struct {
+	OK                 bool    `json:"ok"`
+	State              string  `json:"state"`
+	TimeInStateSeconds float64 `json:"time_in_state_seconds"`
+	Reason             string  `json:"reason,omitempty"`
+}
+
+func NewProbes(args cmdargs.RunArgs, dg DepGraph) (*Probes, error) {
+	clock, err := dg.GetClock()
+	if err != nil {
+		return nil, fmt.Errorf("get clock: %w", err)
+	}
+
+	runner, err := dg.GetRunner()
+	if err != nil {
+		return nil, fmt.Errorf("get runner: %w", err)
+	}
+
+	coordinator, err := dg.GetCoordinator(args)
+	if err != nil {
+		return nil, fmt.Errorf("get coordinator: %w", err)
+	}
+
+	readyMode := args.ReadyMode
+	switch readyMode {
+	case "":
+		readyMode = ReadyConnected
+	case ReadyConnected, ReadyLeader:
+	default:
+		return nil, fmt.Errorf("unknown ready mode %q", readyMode)
+	}
+
+	return &Probes{
+		clock:           clock,
+		runner:          runner,
+		coordinator:     coordinator,
+		readyMode:       readyMode,
+		stuckTimeout:    args.StuckTimeout,
+		failoverTimeout: args.FailoverTimeout,
+	}, nil
+}
+
+// Probes serves the liveness, readiness and leadership probes of the node.
+type Probes struct {
+	clock           extra.Clock
+	runner          run.Runner
+	coordinator     coordination.Coordinator
+	readyMode       string
+	stuckTimeout    time.Duration
+	failoverTimeout time.Duration
+}
+
+// Mount registers the probe routes on mux.
+func (p *Probes) Mount(mux Mux) {
+	mux.Handle("GET /healthz", http.HandlerFunc(p.healthz))
+	mux.Handle("GET /readyz", http.HandlerFunc(p.readyz))
+	mux.Handle("GET /leader", http.HandlerFunc(p.leader))
+}
+
+// healthz fails once the state machine is not running, stays in a transient
+// state longer than the stuck timeout or in Failover longer than the failover
+// timeout.
+func (p *Probes) healthz(w http.ResponseWriter, _ *http.Request) {
+	report := p.report()
+
+	switch {
+	case report.State == "":
+		report.Reason = "state machine is not running"
+	case transient[report.State] && p.stuck(report, p.stuckTimeout):
+		report.Reason = fmt.Sprintf("stuck in %s for longer than %s", report.State, p.stuckTimeout)
+	case report.State == failoverState && p.stuck(report, p.failoverTimeout):
+		report.Reason = fmt.Sprintf("in %s for longer than %s", report.State, p.failoverTimeout)
+	default:
+		report.OK = true
+	}
+
+	writeReport(w, report)
+}
+
+func (p *Probes) readyz(w http.ResponseWriter, _ *http.Request) {
+	report := p.report()
+
+	switch {
+	case p.readyMode == ReadyLeader && report.State != leaderState:
+		report.Reason = "not the leader"
+	case report.State == "" || report.State == stoppingState:
+		report.Reason = "state machine is not running"
+	case !p.coordinator.Connected():
+		report.Reason = "not connected to the coordinator"
+	default:
+		report.OK = true
+	}
+
+	writeReport(w, report)
+}
+
+func (p *Probes) leader(w http.ResponseWriter, _ *http.Request) {
+	report := p.report()
+
+	report.OK = report.State == leaderState
+	if !report.OK {
+		report.Reason = "not the leader"
+	}
+
+	writeReport(w, report)
+}
+
+func (p *Probes) report() Report {
+	state, since := p.runner.Current()
+
+	report := Report{State: state}
+	if !since.IsZero() {
+		report.TimeInStateSeconds = p.clock.Now().Sub(since).Seconds()
+	}
+	return report
+}
+
+func (p *Probes) stuck(report Report, timeout time.Duration) bool {
+	return timeout > 0 && report.TimeInStateSeconds > timeout.Seconds()
+}
+
+func writeReport(w http.ResponseWriter, report Report) {
+	code := http.StatusOK
+	if !report.OK {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(report)
+}
@@ -0,0 +1,74 @@
+package run
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// historySize is the number of state runs kept in the transition history.
+const historySize = 128
+
+// HistoryEntry is a single run of a state.
+type HistoryEntry struct {
+	From            string    `json:"from"`
+	To              string    `json:"to"`
+	Entered         time.Time `json:"entered"`
+	DurationSeconds float64   `json:"duration_seconds"`
+	Error           string    `json:"error,omitempty"`
+	Reason          string    `json:"reason,omitempty"`
+}
+
+func NewHistory(size int) *History {
+	return &History{
+		entries: make([]HistoryEntry, 0, size),
+		size:    size,
+	}
+}
+
+// History is a ring buffer of the last state runs, served as JSON.
+type History struct {
+	mu      sync.Mutex
+	entries []HistoryEntry
+	next    int
+	size    int
+}
+
+func (h *History) Add(entry HistoryEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.entries) < h.size {
+		h.entries = append(h.entries, entry)
+		return
+	}
+	h.entries[h.next] = entry
+	h.next = (h.next + 1) % h.size
+}
+
+// Entries returns the recorded runs, the oldest first.
+func (h *History) Entries() []HistoryEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	entries := make([]HistoryEntry, 0, len(h.entries))
+	entries = append(entries, h.entries[h.next:]...)
+	return append(entries, h.entries[:h.next]...)
+}
+
+func (h *History) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(h.Entries())
+}
+
+type reasonKey struct{}
+
+// SetReason records why the running state is about to return, it ends up in
+// the transition history. It does nothing outside of LoopRunner.
+func SetReason(ctx context.Context, reason string) {
+	if r, ok := ctx.Value(reasonKey{}).(*string); ok {
+		*r = reason
+	}
+}
@@ -0,0 +1,215 @@
+package hooks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+)
+
+// queueSize is the number of events that may wait for delivery. Events that
+// do not fit are dropped, so a slow hook never blocks the state machine.
+const queueSize = 64
+
+// Event is a single transition of the state machine.
+type Event struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	Hostname  string    `json:"hostname"`
+	Epoch     int64     `json:"epoch"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Hook is notified about the transitions it was added for.
+type Hook interface {
+	Fire(ctx context.Context, event Event) error
+	String() string
+}
+
+// Match selects the events a hook is fired for.
+type Match func(event Event) bool
+
+// OnEnter matches transitions into state.
+func OnEnter(state string) Match {
+	return func(event Event) bool {
+		return event.To == state && event.From != state
+	}
+}
+
+// OnLeave matches transitions out of state.
+func OnLeave(state string) Match {
+	return func(event Event) bool {
+		return event.From == state && event.To != state
+	}
+}
+
+// OnFollow matches the transitions after which the replica follows: leaving
+// leader, and the first entry into one of followers since the start or since
+// the last leadership, so a replica that never led is covered too. The Match
+// keeps state, every binding needs its own.
+func OnFollow(leader string, followers ...string) Match {
+	following := false
+	return func(event Event) bool {
+		switch {
+		case event.To == leader:
+			following = false
+			return false
+		case event.From == leader:
+			following = true
+			return true
+		case !following && slices.Contains(followers, event.To):
+			following = true
+			return true
+		}
+		return false
+	}
+}
+
+// Always matches every transition.
+func Always(Event) bool {
+	return true
+}
+
+type binding struct {
+	hook  Hook
+	match Match
+}
+
+func NewDispatcher(timeout time.Duration, retries int, epoch func() int64, clock extra.Clock, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		logger:  logger.With("subsystem", "HookDispatcher"),
+		clock:   clock,
+		timeout: timeout,
+		retries: retries,
+		epoch:   epoch,
+		queue:   make(chan Event, queueSize),
+	}
+}
+
+// Dispatcher fires hooks on transitions of the state machine. Events are
+// delivered in order by a single worker, each hook call is bounded by timeout
+// and retried up to retries times. Matches are called by that worker only.
+type Dispatcher struct {
+	logger   *slog.Logger
+	clock    extra.Clock
+	timeout  time.Duration
+	retries  int
+	epoch    func() int64
+	bindings []binding
+	queue    chan Event
+}
+
+// Add registers hook for the events selected by match. It must be called
+// before Run.
+func (d *Dispatcher) Add(hook Hook, match Match) {
+	d.bindings = append(d.bindings, binding{hook: hook, match: match})
+}
+
+// Empty reports whether no hooks are registered.
+func (d *Dispatcher) Empty() bool {
+	return len(d.bindings) == 0
+}
+
+// OnTransition is a run.TransitionHook queueing the transition for delivery.
+// It never blocks.
+func (d *Dispatcher) OnTransition(ctx context.Context, from, to run.AutomataState) {
+	event := Event{
+		From:      stateName(from),
+		To:        stateName(to),
+		Hostname:  extra.Hostname(),
+		Epoch:     d.epoch(),
+		Timestamp: d.clock.Now(),
+	}
+
+	select {
+	case d.queue <- event:
+	default:
+		d.logger.LogAttrs(ctx, slog.LevelWarn, "hook queue is full, transition dropped",
+			slog.String("from", event.From),
+			slog.String("to", event.To))
+	}
+}
+
+// Run delivers queued events until ctx is done. The events queued by then are
+// still delivered, once each, so the final transitions are not lost.
+func (d *Dispatcher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			d.drain(context.WithoutCancel(ctx))
+			return
+		case event := <-d.queue:
+			d.dispatch(ctx, event, d.retries)
+		}
+	}
+}
+
+func (d *Dispatcher) drain(ctx context.Context) {
+	for {
+		select {
+		case event := <-d.queue:
+			d.dispatch(ctx, event, 0)
+		default:
+			return
+		}
+	}
+}
+
+func (d *Dispatcher) dispatch(ctx context.Context, event Event, retries int) {
+	for _, b := range d.bindings {
+		if !b.match(event) {
+			continue
+		}
+
+		err := d.fire(ctx, b.hook, event, retries)
+		if err != nil {
+			d.logger.LogAttrs(ctx, slog.LevelError, "hook failed",
+				slog.String("hook", b.hook.String()),
+				slog.String("from", event.From),
+				slog.String("to", event.To),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+func (d *Dispatcher) fire(ctx context.Context, hook Hook, event Event, retries int) error {
+	delay := d.timeout / 4
+	for attempt := 0; ; attempt++ {
+		callCtx, cancel := context.WithTimeout(ctx, d.timeout)
+		err := hook.Fire(callCtx, event)
+		cancel()
+		if err == nil {
+			d.logger.LogAttrs(ctx, slog.LevelInfo, "hook fired",
+				slog.String("hook", hook.String()),
+				slog.String("from", event.From),
+				slog.String("to", event.To))
+			return nil
+		}
+		if attempt >= retries {
+			return fmt.Errorf("after %d attempts: %w", attempt+1, err)
+		}
+
+		d.logger.LogAttrs(ctx, slog.LevelWarn, "hook attempt failed, retrying",
+			slog.String("hook", hook.String()),
+			slog.String("error", err.Error()),
+			slog.Duration("delay", delay))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-d.clock.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+func stateName(state run.AutomataState) string {
+	if state == nil {
+		return ""
+	}
+	return state.String()
+}
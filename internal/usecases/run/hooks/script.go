@@ -0,0 +1,51 @@
+package hooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+var _ Hook = &Script{}
+
+func NewScript(path string) *Script {
+	return &Script{path: path}
+}
+
+// Script runs an executable keepalived-style: with the target and the source
+// state as arguments, the event in ELECTION_* variables and as JSON on stdin.
+type Script struct {
+	path string
+}
+
+func (s *Script) Fire(ctx context.Context, event Event) error {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("marshal event: %w", err)
+	}
+
+	var output bytes.Buffer
+	cmd := exec.CommandContext(ctx, s.path, event.To, event.From)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("ELECTION_FROM=%s", event.From),
+		fmt.Sprintf("ELECTION_TO=%s", event.To),
+		fmt.Sprintf("ELECTION_HOSTNAME=%s", event.Hostname),
+		fmt.Sprintf("ELECTION_EPOCH=%d", event.Epoch),
+	)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout, cmd.Stderr = &output, &output
+
+	err = cmd.Run()
+	if err != nil {
+		return fmt.Errorf("run %s: %w: %s", s.path, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+func (s *Script) String() string {
+	return s.path
+}
@@ -2,8 +2,10 @@ package run
 
 import (
 	"context"
+	"errors"
 	"log/slog"
 	"net/http"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -15,53 +17,121 @@ const (
 	LeaderState
 	FailoverState
 	StoppingState
+	MaintenanceState
 )
 
 var mappedStates = map[string]int{
-	"InitState":      InitState,
-	"AttempterState": AttempterState,
-	"LeaderState":    LeaderState,
-	"FailoverState":  FailoverState,
-	"StoppingState":  StoppingState,
+	"InitState":        InitState,
+	"AttempterState":   AttempterState,
+	"LeaderState":      LeaderState,
+	"FailoverState":    FailoverState,
+	"StoppingState":    StoppingState,
+	"MaintenanceState": MaintenanceState,
 }
 
-var (
-	stateChangesTotal = prometheus.NewCounter(prometheus.CounterOpts{
-		Name: "state_changes_total",
-		Help: "Total number of state changes",
-	})
-	stateDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
-		Name:    "state_duration_seconds",
-		Help:    "Duration of states",
-		Buckets: prometheus.DefBuckets,
-	})
-	currentState = prometheus.NewGauge(prometheus.GaugeOpts{
-		Name: "current_state",
-		Help: "Current state",
-	})
-)
+// IsState reports whether name is the name of a state, as in the transitions.
+func IsState(name string) bool {
+	_, ok := mappedStates[name]
+	return ok
+}
 
-func metrics(ctx context.Context, logger *slog.Logger) {
-	prometheus.MustRegister(stateChangesTotal)
-	prometheus.MustRegister(stateDuration)
-	prometheus.MustRegister(currentState)
+// StateNames returns the names of the states in the order of their codes.
+func StateNames() []string {
+	names := make([]string, len(mappedStates))
+	for name, code := range mappedStates {
+		names[code] = name
+	}
+	return names
+}
 
-	http.Handle("/metrics", promhttp.Handler())
-	logger.Info("Starting HTTP metrics server on :8080")
-	defer logger.Info("HTTP metrics server is closed")
+// stateMetrics are the metrics of one runner.
+type stateMetrics struct {
+	stateChangesTotal       prometheus.Counter
+	stateDuration           prometheus.Histogram
+	currentState            prometheus.Gauge
+	shutdownDuration        prometheus.Gauge
+	illegalTransitionsTotal *prometheus.CounterVec
+}
 
-	httpCh := make(chan error)
-	go func() {
-		var err error
-		if err = http.ListenAndServe(":8080", nil); err != nil {
-			logger.Error("Failed to start HTTP metrics server: %v", err)
+func newStateMetrics(registry prometheus.Registerer) *stateMetrics {
+	return &stateMetrics{
+		stateChangesTotal: Register(registry, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "state_changes_total",
+			Help: "Total number of state changes",
+		})),
+		stateDuration: Register(registry, prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "state_duration_seconds",
+			Help:    "Duration of states",
+			Buckets: prometheus.DefBuckets,
+		})),
+		currentState: Register(registry, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "current_state",
+			Help: "Current state",
+		})),
+		shutdownDuration: Register(registry, prometheus.NewGauge(prometheus.GaugeOpts{
+			Name: "shutdown_duration_seconds",
+			Help: "Time from the last shutdown request until the state machine finished",
+		})),
+		illegalTransitionsTotal: Register(registry, prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "illegal_transitions_total",
+			Help: "Total number of transitions rejected by the transition table",
+		}, []string{"from", "to"})),
+	}
+}
+
+// Register adds collector to registry and returns it, or the collector that
+// is already registered under its name, so runners sharing a registry share
+// their metrics as well.
+func Register[T prometheus.Collector](registry prometheus.Registerer, collector T) T {
+	err := registry.Register(collector)
+	var registered prometheus.AlreadyRegisteredError
+	if errors.As(err, &registered) {
+		if existing, ok := registered.ExistingCollector.(T); ok {
+			return existing
 		}
+	}
+	return collector
+}
+
+// serverShutdownTimeout bounds the wait for the requests in flight once the
+// runner stops.
+const serverShutdownTimeout = 5 * time.Second
+
+// metrics serves the metrics of registry, when it can be gathered, and
+// handlers on addr until ctx is done and returns once the server is shut
+// down.
+func metrics(ctx context.Context, logger *slog.Logger, addr string, registry prometheus.Registerer, history *History, handlers map[string]http.Handler) {
+	mux := http.NewServeMux()
+	if gatherer, ok := registry.(prometheus.Gatherer); ok {
+		mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{}))
+	}
+	mux.Handle("/debug/states", history)
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
 
-		httpCh <- err
+	logger.Info("Starting HTTP metrics server on " + addr)
+	defer logger.Info("HTTP metrics server is closed")
+
+	server := &http.Server{Addr: addr, Handler: mux}
+	httpCh := make(chan error, 1)
+	go func() {
+		httpCh <- server.ListenAndServe()
 	}()
 
 	select {
 	case <-ctx.Done():
-	case <-httpCh:
+	case err := <-httpCh:
+		logger.Error("Failed to start HTTP metrics server", slog.String("error", err.Error()))
+		return
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), serverShutdownTimeout)
+	defer cancel()
+
+	err := server.Shutdown(shutdownCtx)
+	if err != nil {
+		logger.Error("Failed to shut HTTP metrics server down", slog.String("error", err.Error()))
 	}
+	<-httpCh
 }
@@ -0,0 +1,161 @@
+package reload
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/tasks"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// liveFields are the RunArgs fields that can change without a restart.
+var liveFields = map[string]bool{
+	"LeaderTimeout":    true,
+	"AttempterTimeout": true,
+	"StorageCapacity":  true,
+}
+
+// ErrNotLive is wrapped by Apply errors caused by changes of fields that need
+// a restart.
+var ErrNotLive = errors.New("settings can not change without a restart")
+
+type periodSetter interface {
+	SetPeriod(period time.Duration)
+}
+
+type storageCapacitySetter interface {
+	SetStorageCapacity(storageCapacity int)
+}
+
+type pollIntervalSetter interface {
+	SetPollInterval(interval time.Duration)
+}
+
+type DepGraph interface {
+	GetLogger() (*slog.Logger, error)
+	GetRegistry() (prometheus.Registerer, error)
+	GetCoordinator(args cmdargs.RunArgs) (coordination.Coordinator, error)
+	GetLeaderTasks(args cmdargs.RunArgs) ([]tasks.LeaderTask, error)
+}
+
+func NewReloader(args cmdargs.RunArgs, dg DepGraph) (*Reloader, error) {
+	logger, err := dg.GetLogger()
+	if err != nil {
+		return nil, fmt.Errorf("get logger: %w", err)
+	}
+
+	registry, err := dg.GetRegistry()
+	if err != nil {
+		return nil, fmt.Errorf("get registry: %w", err)
+	}
+
+	coordinator, err := dg.GetCoordinator(args)
+	if err != nil {
+		return nil, fmt.Errorf("get coordinator: %w", err)
+	}
+
+	leaderTasks, err := dg.GetLeaderTasks(args)
+	if err != nil {
+		return nil, fmt.Errorf("get leader tasks: %w", err)
+	}
+
+	return &Reloader{
+		logger: logger.With("subsystem", "Reloader"),
+		reloadsTotal: run.Register(registry, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Total number of applied configuration reloads",
+		})),
+		reloadFailuresTotal: run.Register(registry, prometheus.NewCounter(prometheus.CounterOpts{
+			Name: "config_reload_failures_total",
+			Help: "Total number of rejected configuration reloads",
+		})),
+		current:     args,
+		coordinator: coordinator,
+		tasks:       leaderTasks,
+	}, nil
+}
+
+// Reloader applies changed settings to the running states and tasks.
+type Reloader struct {
+	logger              *slog.Logger
+	reloadsTotal        prometheus.Counter
+	reloadFailuresTotal prometheus.Counter
+	coordinator         coordination.Coordinator
+	tasks               []tasks.LeaderTask
+
+	mu      sync.Mutex
+	current cmdargs.RunArgs
+}
+
+// Fail counts a reload that failed before it got to Apply, e.g. because the
+// file could not be parsed.
+func (r *Reloader) Fail(err error) {
+	r.reloadFailuresTotal.Inc()
+	r.logger.Error("config reload failed", slog.String("error", err.Error()))
+}
+
+// Apply switches to args. The reload is rejected as a whole when it changes
+// a field that can not change live.
+func (r *Reloader) Apply(args cmdargs.RunArgs) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	changed, rejected := diff(r.current, args)
+	if len(rejected) > 0 {
+		err := fmt.Errorf("%w: %s", ErrNotLive, strings.Join(rejected, ", "))
+		r.Fail(err)
+		return err
+	}
+	if len(changed) == 0 {
+		r.logger.Info("config reloaded, nothing changed")
+		return nil
+	}
+
+	for _, task := range r.tasks {
+		if s, ok := task.(periodSetter); ok {
+			s.SetPeriod(args.LeaderTimeout)
+		}
+		if s, ok := task.(storageCapacitySetter); ok {
+			s.SetStorageCapacity(args.StorageCapacity)
+		}
+	}
+	if s, ok := r.coordinator.(pollIntervalSetter); ok && args.AttempterPolling {
+		s.SetPollInterval(args.AttempterTimeout)
+	}
+
+	r.current = args
+	r.reloadsTotal.Inc()
+	r.logger.Info("config reloaded",
+		slog.String("changed", strings.Join(changed, ", ")),
+		slog.Duration("leader-timeout", args.LeaderTimeout),
+		slog.Duration("attempter-timeout", args.AttempterTimeout),
+		slog.Int("storage-capacity", args.StorageCapacity),
+	)
+	return nil
+}
+
+// diff returns the names of the changed live fields and of the changed
+// fields that need a restart.
+func diff(old, new cmdargs.RunArgs) (changed, rejected []string) {
+	oldValue, newValue := reflect.ValueOf(old), reflect.ValueOf(new)
+	for i := 0; i < oldValue.NumField(); i++ {
+		name := oldValue.Type().Field(i).Name
+		if reflect.DeepEqual(oldValue.Field(i).Interface(), newValue.Field(i).Interface()) {
+			continue
+		}
+		if liveFields[name] {
+			changed = append(changed, name)
+		} else {
+			rejected = append(rejected, name)
+		}
+	}
+	return changed, rejected
+}
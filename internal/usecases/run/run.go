@@ -4,47 +4,232 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"net/http"
+	"sync"
 	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+	"github.com/prometheus/client_golang/prometheus"
 )
 
 var _ Runner = &LoopRunner{}
 
 type Runner interface {
 	Run(ctx context.Context, state AutomataState) error
+	AddHook(hook TransitionHook)
+	History() []HistoryEntry
+	Current() (state string, since time.Time)
+	Handle(pattern string, handler http.Handler)
+	SetAddr(addr string)
 }
 
-func NewLoopRunner(logger *slog.Logger) *LoopRunner {
+// TransitionHook is called by LoopRunner before it runs the next state. from
+// is nil for the first state and to is nil once the machine has finished.
+type TransitionHook func(ctx context.Context, from, to AutomataState)
+
+// DefaultAddr is where the HTTP server with metrics and the mounted handlers
+// of the election binary listens.
+const DefaultAddr = ":8080"
+
+// NewLoopRunner returns a runner that keeps its metrics in registry and has
+// no HTTP server until SetAddr.
+func NewLoopRunner(logger *slog.Logger, clock extra.Clock, registry prometheus.Registerer) *LoopRunner {
 	logger = logger.With("subsystem", "StateRunner")
 	return &LoopRunner{
-		logger: logger,
+		logger:      logger,
+		clock:       clock,
+		registry:    registry,
+		metrics:     newStateMetrics(registry),
+		transitions: Transitions,
+		history:     NewHistory(historySize),
 	}
 }
 
+// LoopRunner runs states one after another, rejecting every transition the
+// transition table does not allow.
 type LoopRunner struct {
-	logger *slog.Logger
+	logger      *slog.Logger
+	clock       extra.Clock
+	registry    prometheus.Registerer
+	metrics     *stateMetrics
+	transitions *TransitionTable
+	history     *History
+
+	mu       sync.Mutex
+	addr     string
+	hooks    []TransitionHook
+	handlers map[string]http.Handler
+	current  string
+	since    time.Time
+}
+
+// SetAddr changes the address of the HTTP server, an empty addr disables it.
+// It must be called before Run.
+func (r *LoopRunner) SetAddr(addr string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.addr = addr
+}
+
+// Handle serves handler on the metrics HTTP server. It must be called before
+// Run.
+func (r *LoopRunner) Handle(pattern string, handler http.Handler) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.handlers == nil {
+		r.handlers = make(map[string]http.Handler)
+	}
+	r.handlers[pattern] = handler
+}
+
+// Current returns the running state and when it was entered. The state is
+// empty before Run and after it returns.
+func (r *LoopRunner) Current() (string, time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current, r.since
+}
+
+func (r *LoopRunner) AddHook(hook TransitionHook) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+// History returns the last state runs, the oldest first.
+func (r *LoopRunner) History() []HistoryEntry {
+	return r.history.Entries()
 }
 
 func (r *LoopRunner) Run(ctx context.Context, state AutomataState) error {
+	requested := make(chan time.Time, 1)
+	stopWatch := context.AfterFunc(ctx, func() {
+		requested <- r.clock.Now()
+	})
+	defer func() {
+		if !stopWatch() {
+			r.reportShutdown(<-requested)
+		}
+	}()
+
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
-	go metrics(ctx, r.logger)
+	r.mu.Lock()
+	addr, handlers := r.addr, r.handlers
+	r.mu.Unlock()
 
+	if addr != "" {
+		// The server is shut down before Run returns, so its address is free
+		// for the next runner.
+		serverDone := make(chan struct{})
+		go func() {
+			defer close(serverDone)
+			metrics(ctx, r.logger, addr, r.registry, r.history, handlers)
+		}()
+		defer func() {
+			cancel()
+			<-serverDone
+		}()
+	}
+	defer r.setCurrent(nil, time.Time{})
+
+	var prev AutomataState
 	for state != nil {
+		err := r.check(ctx, prev, state)
+		if err != nil {
+			if prev != nil {
+				r.notify(ctx, prev, nil)
+			}
+			return err
+		}
+
+		r.notify(ctx, prev, state)
 		r.logger.LogAttrs(ctx, slog.LevelInfo, "start running state", slog.String("state", state.String()))
 
-		start := time.Now()
-		currentState.Set(float64(mappedStates[state.String()]))
+		start := r.clock.Now()
+		r.metrics.currentState.Set(float64(mappedStates[state.String()]))
+		r.setCurrent(state, start)
 
-		var err error
-		state, err = state.Run(ctx)
-		stateChangesTotal.Inc()
-		stateDuration.Observe(time.Since(start).Seconds())
+		var reason string
+		prev = state
+		state, err = state.Run(context.WithValue(ctx, reasonKey{}, &reason))
+		r.metrics.stateChangesTotal.Inc()
+		r.metrics.stateDuration.Observe(r.clock.Now().Sub(start).Seconds())
+		r.record(prev, state, start, reason, err)
 
 		if err != nil {
-			return fmt.Errorf("state %s run: %w", state.String(), err)
+			r.notify(ctx, prev, nil)
+			return fmt.Errorf("state %s run: %w", prev.String(), err)
 		}
 	}
+
+	r.notify(ctx, prev, nil)
+
+	err := r.check(ctx, prev, nil)
+	if err != nil {
+		return err
+	}
+
 	r.logger.LogAttrs(ctx, slog.LevelInfo, "no new state, finish")
 	return nil
 }
+
+// reportShutdown records how long the machine took to finish after the
+// shutdown was requested at requested.
+func (r *LoopRunner) reportShutdown(requested time.Time) {
+	took := r.clock.Now().Sub(requested)
+	r.metrics.shutdownDuration.Set(took.Seconds())
+	r.logger.LogAttrs(context.Background(), slog.LevelInfo, "shutdown finished", slog.Duration("took", took))
+}
+
+func (r *LoopRunner) setCurrent(state AutomataState, since time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.current, r.since = stateName(state), since
+}
+
+func (r *LoopRunner) record(from, to AutomataState, entered time.Time, reason string, err error) {
+	entry := HistoryEntry{
+		From:            stateName(from),
+		To:              stateName(to),
+		Entered:         entered,
+		DurationSeconds: r.clock.Now().Sub(entered).Seconds(),
+		Reason:          reason,
+	}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	r.history.Add(entry)
+}
+
+func (r *LoopRunner) check(ctx context.Context, from, to AutomataState) error {
+	fromName, toName := stateName(from), stateName(to)
+
+	err := r.transitions.Check(fromName, toName)
+	if err != nil {
+		r.metrics.illegalTransitionsTotal.WithLabelValues(fromName, toName).Inc()
+		r.logger.LogAttrs(ctx, slog.LevelError, "illegal transition",
+			slog.String("from", fromName),
+			slog.String("to", toName))
+	}
+	return err
+}
+
+func (r *LoopRunner) notify(ctx context.Context, from, to AutomataState) {
+	r.mu.Lock()
+	hooks := r.hooks
+	r.mu.Unlock()
+
+	for _, hook := range hooks {
+		hook(ctx, from, to)
+	}
+}
+
+func stateName(state AutomataState) string {
+	if state == nil {
+		return ""
+	}
+	return state.String()
+}
@@ -2,15 +2,15 @@ package states
 
 import (
 	"context"
-	"errors"
 	"fmt"
 	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
-	"github.com/go-zookeeper/zk"
 )
 
 func NewAttempterState(args cmdargs.RunArgs, dg DepGraph) (*AttempterState, error) {
@@ -19,73 +19,122 @@ func NewAttempterState(args cmdargs.RunArgs, dg DepGraph) (*AttempterState, erro
 		return nil, fmt.Errorf("get logger: %w", err)
 	}
 
+	coordinator, err := dg.GetCoordinator(args)
+	if err != nil {
+		return nil, fmt.Errorf("get coordinator: %w", err)
+	}
+
+	maintenance, err := dg.GetMaintenanceState(args)
+	if err != nil {
+		return nil, fmt.Errorf("get maintenance state: %w", err)
+	}
+
+	clock, err := dg.GetClock()
+	if err != nil {
+		return nil, fmt.Errorf("get clock: %w", err)
+	}
+
 	return &AttempterState{
-		logger:          logger.With("subsystem", "AttempterState"),
-		zkEphemeralPath: args.ZKEphemeralPath,
-		ticker:          extra.NewTicker(args.AttempterTimeout),
-		args:            args,
-		dg:              dg,
+		logger:      logger.With("subsystem", "AttempterState"),
+		clock:       clock,
+		coordinator: coordinator,
+		maintenance: maintenance,
+		args:        args,
+		dg:          dg,
 	}, nil
 }
 
 type AttempterState struct {
-	logger          *slog.Logger
-	zkEphemeralPath string
-	conn            *zk.Conn
-	ticker          extra.Ticker
-	args            cmdargs.RunArgs
-	dg              DepGraph
+	logger      *slog.Logger
+	clock       extra.Clock
+	coordinator coordination.Coordinator
+	maintenance *MaintenanceState
+	args        cmdargs.RunArgs
+	dg          DepGraph
+
+	mu        sync.Mutex
+	notBefore time.Time
 }
 
-func (s *AttempterState) WithConnection(conn *zk.Conn) *AttempterState {
-	s.conn = conn
-	return s
+func (s *AttempterState) String() string {
+	return "AttempterState"
 }
 
-func (s *AttempterState) Stop() {
-	s.ticker.Stop()
+// HoldOff keeps the next runs from campaigning for d, so that after a resign
+// another replica gets the lock instead of this one taking it right back.
+func (s *AttempterState) HoldOff(d time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.notBefore = s.clock.Now().Add(d)
 }
 
-func (s *AttempterState) String() string {
-	return "AttempterState"
+func (s *AttempterState) holdOffLeft() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.notBefore.Sub(s.clock.Now())
 }
 
 func (s *AttempterState) Run(ctx context.Context) (run.AutomataState, error) {
-	if s.conn == nil {
+	if !s.coordinator.Connected() {
+		run.SetReason(ctx, "coordinator is not connected")
 		return s.dg.GetFailoverState(s.args)
 	}
 
-	resChan := make(chan error)
-	go func() {
-		for range s.ticker.Chan() {
-			_, err := s.conn.Create(s.zkEphemeralPath, []byte(fmt.Sprintf("hostname: %s, time: %s", hostname(), time.Now())), zk.FlagEphemeral, zk.WorldACL(zk.PermAll))
-			if !errors.Is(err, zk.ErrNodeExists) {
-				resChan <- err
-				return
-			}
-
-			s.logger.LogAttrs(ctx, slog.LevelInfo, "failed attempt: node is already exist",
-				slog.String("time", time.Now().String()),
-				slog.String("hostname", hostname()),
-			)
+	if wait := s.holdOffLeft(); wait > 0 {
+		s.logger.LogAttrs(ctx, slog.LevelInfo, "holding off the campaign", slog.Duration("wait", wait))
+
+		timer := s.clock.NewTimer(wait)
+		defer timer.Stop()
+
+		select {
+		case <-ctx.Done():
+			run.SetReason(ctx, "shutdown requested")
+			return s.dg.GetStoppingState(s.args)
+		case <-s.maintenance.Paused():
+			run.SetReason(ctx, "paused")
+			return s.dg.GetMaintenanceState(s.args)
+		case <-timer.Chan():
 		}
+	}
+
+	acquireCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	resChan := make(chan error, 1)
+	go func() {
+		resChan <- s.coordinator.Acquire(acquireCtx)
 	}()
 
 	select {
 	case <-ctx.Done():
+		run.SetReason(ctx, "shutdown requested")
 		return s.dg.GetStoppingState(s.args)
 
-	case err := <-resChan:
+	case <-s.maintenance.Paused():
+		cancel()
+		<-resChan
+
+		// Acquire may have won the race with the cancellation, and a cancelled
+		// one may leave a candidate behind, e.g. a ZooKeeper node, that would
+		// block the election while paused.
+		err := s.coordinator.Release(ctx)
 		if err != nil {
-			s.logger.LogAttrs(ctx, slog.LevelError, "error occurred", slog.String("msg", err.Error()))
+			s.logger.LogAttrs(ctx, slog.LevelError, "can not release leadership", slog.String("msg", err.Error()))
+			run.SetReason(ctx, "paused, release failed: "+err.Error())
 			return s.dg.GetFailoverState(s.args)
 		}
 
-		leaderState, err := s.dg.GetLeaderState(s.args)
+		run.SetReason(ctx, "paused")
+		return s.dg.GetMaintenanceState(s.args)
+
+	case err := <-resChan:
 		if err != nil {
-			return nil, err
+			s.logger.LogAttrs(ctx, slog.LevelError, "error occurred", slog.String("msg", err.Error()))
+			run.SetReason(ctx, "can not acquire leadership: "+err.Error())
+			return s.dg.GetFailoverState(s.args)
 		}
 
-		return leaderState.WithConnection(s.conn), nil
+		run.SetReason(ctx, "leadership acquired")
+		return s.dg.GetLeaderState(s.args)
 	}
 }
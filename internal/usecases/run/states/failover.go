@@ -2,13 +2,15 @@ package states
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"time"
 
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
-	"github.com/go-zookeeper/zk"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/backoff"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
 )
 
 func NewFailoverState(args cmdargs.RunArgs, dg DepGraph) (*FailoverState, error) {
@@ -17,72 +19,82 @@ func NewFailoverState(args cmdargs.RunArgs, dg DepGraph) (*FailoverState, error)
 		return nil, fmt.Errorf("get logger: %w", err)
 	}
 
+	coordinator, err := dg.GetCoordinator(args)
+	if err != nil {
+		return nil, fmt.Errorf("get coordinator: %w", err)
+	}
+
+	clock, err := dg.GetClock()
+	if err != nil {
+		return nil, fmt.Errorf("get clock: %w", err)
+	}
+
 	return &FailoverState{
-		logger:         logger.With("subsystem", "FailoverState"),
-		zkServers:      args.ZkServers,
-		sessionTimeout: args.SessionTimeout,
-		dg:             dg,
-		args:           args,
+		logger:      logger.With("subsystem", "FailoverState"),
+		clock:       clock,
+		coordinator: coordinator,
+		backoff: backoff.Config{
+			Strategy:   args.FailoverBackoff,
+			Initial:    args.FailoverInitialDelay,
+			Max:        args.FailoverMaxDelay,
+			MaxElapsed: args.FailoverMaxElapsed,
+			MaxRetries: args.FailoverMaxRetries,
+		},
+		dg:   dg,
+		args: args,
 	}, nil
 }
 
 type FailoverState struct {
-	logger         *slog.Logger
-	zkServers      []string
-	sessionTimeout time.Duration
-	args           cmdargs.RunArgs
-	dg             DepGraph
+	logger      *slog.Logger
+	clock       extra.Clock
+	coordinator coordination.Coordinator
+	backoff     backoff.Config
+	args        cmdargs.RunArgs
+	dg          DepGraph
 }
 
 func (s *FailoverState) String() string {
 	return "FailoverState"
 }
 
-func (s *FailoverState) connectWithExponentialBackoff(ctx context.Context, resChan chan result) {
-	const maxRetries = 5
-	const initialDelay = time.Second
-	delay := initialDelay
+func (s *FailoverState) connectWithBackoff(ctx context.Context, resChan chan error) {
+	b := backoff.New(s.backoff, s.clock, nil)
 
-	for attempt := 0; attempt < maxRetries; attempt++ {
-		conn, _, err := zk.Connect(s.zkServers, s.sessionTimeout)
+	for {
+		err := s.coordinator.Connect(ctx)
 		if err == nil {
-			resChan <- result{
-				conn: conn,
-			}
+			resChan <- nil
 			return
 		}
 
-		s.logger.LogAttrs(ctx, slog.LevelError, fmt.Sprintf("Error connecting to Zookeeper on attempt %d", attempt+1), slog.String("msg", err.Error()))
-
-		// Increase delay exponentially
-		delay *= 2
-		time.Sleep(delay)
-	}
+		s.logger.LogAttrs(ctx, slog.LevelError, fmt.Sprintf("Error connecting to coordinator on attempt %d", b.Attempt()+1), slog.String("msg", err.Error()))
 
-	resChan <- result{
-		err: fmt.Errorf("unable to connect to Zookeeper after %d attempts", maxRetries),
+		waitErr := b.Wait(ctx)
+		if waitErr != nil {
+			resChan <- fmt.Errorf("unable to connect to coordinator after %d attempts: %w", b.Attempt()+1, errors.Join(waitErr, err))
+			return
+		}
 	}
 }
 
 func (s *FailoverState) Run(ctx context.Context) (run.AutomataState, error) {
-	resChan := make(chan result)
-	go s.connectWithExponentialBackoff(ctx, resChan)
+	resChan := make(chan error, 1)
+	go s.connectWithBackoff(ctx, resChan)
 
 	select {
 	case <-ctx.Done():
+		run.SetReason(ctx, "shutdown requested")
 		return s.dg.GetStoppingState(s.args)
 
-	case res := <-resChan:
-		if res.err != nil {
-			s.logger.LogAttrs(ctx, slog.LevelError, "can not connect to zookeeper", slog.String("msg", res.err.Error()))
-			return s.dg.GetStoppingState(s.args)
-		}
-
-		attempterState, err := s.dg.GetAttempterState(s.args)
+	case err := <-resChan:
 		if err != nil {
-			return nil, err
+			s.logger.LogAttrs(ctx, slog.LevelError, "can not connect to coordinator", slog.String("msg", err.Error()))
+			run.SetReason(ctx, err.Error())
+			return s.dg.GetStoppingState(s.args)
 		}
 
-		return attempterState.WithConnection(res.conn), nil
+		run.SetReason(ctx, "reconnected to coordinator")
+		return s.dg.GetAttempterState(s.args)
 	}
 }
@@ -0,0 +1,188 @@
+package states
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/backoff"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/tasks"
+)
+
+// testDepGraph builds every state anew around one coordinator and clock.
+type testDepGraph struct {
+	clock       *extra.FakeClock
+	coordinator coordination.Coordinator
+}
+
+func (dg *testDepGraph) GetLogger() (*slog.Logger, error) {
+	return slog.New(slog.NewTextHandler(io.Discard, nil)), nil
+}
+
+func (dg *testDepGraph) GetClock() (extra.Clock, error) {
+	return dg.clock, nil
+}
+
+func (dg *testDepGraph) GetCoordinator(cmdargs.RunArgs) (coordination.Coordinator, error) {
+	return dg.coordinator, nil
+}
+
+func (dg *testDepGraph) GetLeaderTasks(cmdargs.RunArgs) ([]tasks.LeaderTask, error) {
+	return nil, nil
+}
+
+func (dg *testDepGraph) GetAttempterState(args cmdargs.RunArgs) (*AttempterState, error) {
+	return NewAttempterState(args, dg)
+}
+
+func (dg *testDepGraph) GetLeaderState(args cmdargs.RunArgs) (*LeaderState, error) {
+	return NewLeaderState(args, dg)
+}
+
+func (dg *testDepGraph) GetFailoverState(args cmdargs.RunArgs) (*FailoverState, error) {
+	return NewFailoverState(args, dg)
+}
+
+func (dg *testDepGraph) GetStoppingState(args cmdargs.RunArgs) (*StoppingState, error) {
+	return NewStoppingState(args, dg)
+}
+
+func (dg *testDepGraph) GetMaintenanceState(args cmdargs.RunArgs) (*MaintenanceState, error) {
+	return NewMaintenanceState(args, dg)
+}
+
+var errUnreachable = errors.New("coordinator unreachable")
+
+// flakyCoordinator fails the first failures connects.
+type flakyCoordinator struct {
+	coordination.Coordinator
+
+	mu       sync.Mutex
+	failures int
+	connects int
+}
+
+func (c *flakyCoordinator) Connect(context.Context) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.connects++
+	if c.connects <= c.failures {
+		return errUnreachable
+	}
+	return nil
+}
+
+func (c *flakyCoordinator) attempts() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.connects
+}
+
+type failoverResult struct {
+	state run.AutomataState
+	err   error
+}
+
+func runFailover(t *testing.T, ctx context.Context, args cmdargs.RunArgs, dg *testDepGraph) <-chan failoverResult {
+	t.Helper()
+
+	state, err := NewFailoverState(args, dg)
+	if err != nil {
+		t.Fatalf("new failover state: %v", err)
+	}
+
+	res := make(chan failoverResult, 1)
+	go func() {
+		next, err := state.Run(ctx)
+		res <- failoverResult{next, err}
+	}()
+	return res
+}
+
+func TestFailoverBacksOffUntilConnected(t *testing.T) {
+	coordinator := &flakyCoordinator{failures: 3}
+	dg := &testDepGraph{clock: extra.NewFakeClock(time.Unix(0, 0)), coordinator: coordinator}
+	args := cmdargs.RunArgs{
+		FailoverBackoff:      backoff.StrategyExponential,
+		FailoverInitialDelay: time.Second,
+	}
+
+	res := runFailover(t, context.Background(), args, dg)
+
+	// Every failed connect waits twice as long as the one before.
+	for i, delay := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second} {
+		dg.clock.BlockUntil(1)
+		dg.clock.Advance(delay - time.Millisecond)
+		if got := coordinator.attempts(); got != i+1 {
+			t.Fatalf("%d connects before the delay %d passed, want %d", got, i, i+1)
+		}
+		dg.clock.Advance(time.Millisecond)
+	}
+
+	r := <-res
+	if r.err != nil {
+		t.Fatalf("run: %v", r.err)
+	}
+	if _, ok := r.state.(*AttempterState); !ok {
+		t.Fatalf("next state is %v, want AttempterState", r.state)
+	}
+	if got := coordinator.attempts(); got != 4 {
+		t.Fatalf("%d connects, want 4", got)
+	}
+}
+
+func TestFailoverStopsWhenExhausted(t *testing.T) {
+	coordinator := &flakyCoordinator{failures: 100}
+	dg := &testDepGraph{clock: extra.NewFakeClock(time.Unix(0, 0)), coordinator: coordinator}
+	args := cmdargs.RunArgs{
+		FailoverBackoff:      backoff.StrategyConstant,
+		FailoverInitialDelay: time.Second,
+		FailoverMaxRetries:   2,
+	}
+
+	res := runFailover(t, context.Background(), args, dg)
+	for range 2 {
+		dg.clock.BlockUntil(1)
+		dg.clock.Advance(time.Second)
+	}
+
+	r := <-res
+	if r.err != nil {
+		t.Fatalf("run: %v", r.err)
+	}
+	if _, ok := r.state.(*StoppingState); !ok {
+		t.Fatalf("next state is %v, want StoppingState", r.state)
+	}
+	if got := coordinator.attempts(); got != 3 {
+		t.Fatalf("%d connects, want 3", got)
+	}
+}
+
+func TestFailoverStopsOnShutdown(t *testing.T) {
+	coordinator := &flakyCoordinator{failures: 100}
+	dg := &testDepGraph{clock: extra.NewFakeClock(time.Unix(0, 0)), coordinator: coordinator}
+	args := cmdargs.RunArgs{FailoverInitialDelay: time.Hour}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	res := runFailover(t, ctx, args, dg)
+
+	dg.clock.BlockUntil(1)
+	cancel()
+
+	r := <-res
+	if r.err != nil {
+		t.Fatalf("run: %v", r.err)
+	}
+	if _, ok := r.state.(*StoppingState); !ok {
+		t.Fatalf("next state is %v, want StoppingState", r.state)
+	}
+}
@@ -4,19 +4,24 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
-	"time"
 
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
-	"github.com/go-zookeeper/zk"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/tasks"
 )
 
 type DepGraph interface {
 	GetLogger() (*slog.Logger, error)
+	GetClock() (extra.Clock, error)
+	GetCoordinator(args cmdargs.RunArgs) (coordination.Coordinator, error)
+	GetLeaderTasks(args cmdargs.RunArgs) ([]tasks.LeaderTask, error)
 	GetAttempterState(args cmdargs.RunArgs) (*AttempterState, error)
 	GetLeaderState(args cmdargs.RunArgs) (*LeaderState, error)
 	GetFailoverState(args cmdargs.RunArgs) (*FailoverState, error)
 	GetStoppingState(args cmdargs.RunArgs) (*StoppingState, error)
+	GetMaintenanceState(args cmdargs.RunArgs) (*MaintenanceState, error)
 }
 
 func NewInitState(args cmdargs.RunArgs, dg DepGraph) (*InitState, error) {
@@ -25,58 +30,50 @@ func NewInitState(args cmdargs.RunArgs, dg DepGraph) (*InitState, error) {
 		return nil, fmt.Errorf("get logger: %w", err)
 	}
 
+	coordinator, err := dg.GetCoordinator(args)
+	if err != nil {
+		return nil, fmt.Errorf("get coordinator: %w", err)
+	}
+
 	return &InitState{
-		logger:         logger.With("subsystem", "InitState"),
-		zkServers:      args.ZkServers,
-		sessionTimeout: args.SessionTimeout,
-		dg:             dg,
-		args:           args,
+		logger:      logger.With("subsystem", "InitState"),
+		coordinator: coordinator,
+		dg:          dg,
+		args:        args,
 	}, nil
 }
 
 type InitState struct {
-	logger         *slog.Logger
-	zkServers      []string
-	sessionTimeout time.Duration
-	args           cmdargs.RunArgs
-	dg             DepGraph
+	logger      *slog.Logger
+	coordinator coordination.Coordinator
+	args        cmdargs.RunArgs
+	dg          DepGraph
 }
 
 func (s *InitState) String() string {
 	return "InitState"
 }
 
-type result struct {
-	conn *zk.Conn
-	err  error
-}
-
 func (s *InitState) Run(ctx context.Context) (run.AutomataState, error) {
-	resChan := make(chan result)
+	resChan := make(chan error, 1)
 	go func() {
-		conn, _, err := zk.Connect(s.zkServers, s.sessionTimeout)
-		resChan <- result{
-			conn: conn,
-			err:  err,
-		}
+		resChan <- s.coordinator.Connect(ctx)
 	}()
 
 	select {
 	case <-ctx.Done():
+		run.SetReason(ctx, "shutdown requested")
 		return s.dg.GetStoppingState(s.args)
 
-	case res := <-resChan:
-		if res.err != nil {
-			s.logger.LogAttrs(ctx, slog.LevelError, "can not connect to zookeeper", slog.String("msg", res.err.Error()))
+	case err := <-resChan:
+		if err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelError, "can not connect to coordinator", slog.String("msg", err.Error()))
 
+			run.SetReason(ctx, "can not connect to coordinator: "+err.Error())
 			return s.dg.GetFailoverState(s.args)
 		}
 
-		attempterState, err := s.dg.GetAttempterState(s.args)
-		if err != nil {
-			return nil, err
-		}
-
-		return attempterState.WithConnection(res.conn), nil
+		run.SetReason(ctx, "connected to coordinator")
+		return s.dg.GetAttempterState(s.args)
 	}
 }
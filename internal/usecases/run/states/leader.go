@@ -2,20 +2,26 @@ package states
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"os"
-	"path/filepath"
+	"sync"
 	"time"
 
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
-	"github.com/go-zookeeper/zk"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/tasks"
 )
 
 const (
-	layout = "2006-01-02_15-04-05"
+	// taskStopTimeout bounds how long leaving the leader state waits for the
+	// leader tasks to finish their in-flight work.
+	taskStopTimeout = 10 * time.Second
+	// taskStopMargin is waited on top of the exec grace period, so that the
+	// child is gone before the next leader can start another one.
+	taskStopMargin = 2 * time.Second
 )
 
 func NewLeaderState(args cmdargs.RunArgs, dg DepGraph) (*LeaderState, error) {
@@ -24,34 +30,65 @@ func NewLeaderState(args cmdargs.RunArgs, dg DepGraph) (*LeaderState, error) {
 		return nil, fmt.Errorf("get logger: %w", err)
 	}
 
+	coordinator, err := dg.GetCoordinator(args)
+	if err != nil {
+		return nil, fmt.Errorf("get coordinator: %w", err)
+	}
+
+	leaderTasks, err := dg.GetLeaderTasks(args)
+	if err != nil {
+		return nil, fmt.Errorf("get leader tasks: %w", err)
+	}
+
+	maintenance, err := dg.GetMaintenanceState(args)
+	if err != nil {
+		return nil, fmt.Errorf("get maintenance state: %w", err)
+	}
+
+	clock, err := dg.GetClock()
+	if err != nil {
+		return nil, fmt.Errorf("get clock: %w", err)
+	}
+
+	stopping, err := dg.GetStoppingState(args)
+	if err != nil {
+		return nil, fmt.Errorf("get stopping state: %w", err)
+	}
+
 	return &LeaderState{
-		logger:          logger.With("subsystem", "LeaderState"),
-		fileDir:         args.FileDir,
-		storageCapacity: args.StorageCapacity,
-		zkEphemeralPath: args.ZKEphemeralPath,
-		dg:              dg,
-		ticker:          extra.NewTicker(args.LeaderTimeout),
+		logger:      logger.With("subsystem", "LeaderState"),
+		clock:       clock,
+		identity:    extra.Identity(args.Identity),
+		coordinator: coordinator,
+		tasks:       leaderTasks,
+		maintenance: maintenance,
+		stopping:    stopping,
+		dg:          dg,
+		args:        args,
+		resign:      make(chan struct{}, 1),
 	}, nil
 }
 
 type LeaderState struct {
-	logger          *slog.Logger
-	ticker          extra.Ticker
-	fileDir         string
-	storageCapacity int
-	zkEphemeralPath string
-	conn            *zk.Conn
-	dg              DepGraph
-	args            cmdargs.RunArgs
-}
-
-func (s *LeaderState) WithConnection(conn *zk.Conn) *LeaderState {
-	s.conn = conn
-	return s
+	logger      *slog.Logger
+	clock       extra.Clock
+	identity    string
+	coordinator coordination.Coordinator
+	tasks       []tasks.LeaderTask
+	maintenance *MaintenanceState
+	stopping    *StoppingState
+	dg          DepGraph
+	args        cmdargs.RunArgs
+	resign      chan struct{}
 }
 
-func (s *LeaderState) Stop() {
-	s.ticker.Stop()
+// Resign makes the running LeaderState give leadership up and return to
+// AttempterState. Requests made while not leading are dropped on the next run.
+func (s *LeaderState) Resign() {
+	select {
+	case s.resign <- struct{}{}:
+	default:
+	}
 }
 
 func (s *LeaderState) String() string {
@@ -59,85 +96,150 @@ func (s *LeaderState) String() string {
 }
 
 func (s *LeaderState) Run(ctx context.Context) (run.AutomataState, error) {
-	if s.conn == nil {
+	if !s.coordinator.Connected() {
+		run.SetReason(ctx, "coordinator is not connected")
 		return s.dg.GetFailoverState(s.args)
 	}
 
-	failChan := make(chan error)
-	go func() {
-		for range s.ticker.Chan() {
-			if s.conn.State() != zk.StateHasSession {
-				failChan <- nil
-				return
-			}
+	select {
+	case <-s.resign:
+	default:
+	}
 
-			fileCount, err := countFiles(s.fileDir)
-			if err != nil {
-				failChan <- err
-				return
-			}
+	lost := s.coordinator.Lost()
+	info := tasks.LeadershipInfo{
+		Identity: s.identity,
+		Epoch:    s.coordinator.Epoch(),
+		Since:    s.clock.Now(),
+	}
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "leading", slog.Int64("epoch", info.Epoch))
 
-			if fileCount >= s.storageCapacity {
-				err := cleanDirectory(s.fileDir)
-				if err != nil {
-					failChan <- err
-					return
-				}
-			}
+	// leaderCtx is cancelled the moment the leader state is left, whatever
+	// the reason, so tasks never outlive leadership.
+	leaderCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	defer s.stopTasks(ctx, cancel, &wg)
 
-			fileName := fmt.Sprintf("%s_%s.txt", hostname(), time.Now().Format(layout))
-			filePath := filepath.Join(s.fileDir, fileName)
-			_, err = os.Create(filePath)
+	failChan := make(chan error, len(s.tasks))
+	for _, task := range s.tasks {
+		wg.Add(1)
+		go func(task tasks.LeaderTask) {
+			defer wg.Done()
+
+			err := task.Start(leaderCtx, info)
 			if err != nil {
 				failChan <- err
-				break
 			}
-
-			s.logger.LogAttrs(ctx, slog.LevelInfo, "created new file",
-				slog.String("filePath", filePath))
-		}
-	}()
+		}(task)
+	}
 
 	select {
 	case <-ctx.Done():
+		run.SetReason(ctx, "shutdown requested")
 		return s.dg.GetStoppingState(s.args)
 
-	case err := <-failChan:
+	case <-lost:
+		run.SetReason(ctx, "leadership lost")
+		return s.dg.GetFailoverState(s.args)
+
+	case <-s.resign:
+		cancel()
+		s.logger.LogAttrs(ctx, slog.LevelInfo, "resigning", slog.Int64("epoch", info.Epoch))
+
+		err := s.coordinator.Release(ctx)
 		if err != nil {
-			s.logger.LogAttrs(ctx, slog.LevelError, fmt.Sprintf("Error from leader file system in directory %s: %v", s.fileDir, err))
-			return s.dg.GetStoppingState(s.args)
+			s.logger.LogAttrs(ctx, slog.LevelError, "can not release leadership", slog.String("msg", err.Error()))
+			run.SetReason(ctx, "resigned, release failed: "+err.Error())
+			return s.dg.GetFailoverState(s.args)
+		}
+		attempter, err := s.dg.GetAttempterState(s.args)
+		if err != nil {
+			return nil, err
+		}
+		// Where candidates race for a lock, we could win it straight back,
+		// queued backends hand it to the next candidate by themselves.
+		if racing, ok := s.coordinator.(coordination.Racing); ok && racing.Racing() {
+			attempter.HoldOff(s.args.LeaseDuration)
 		}
 
-		return s.dg.GetFailoverState(s.args)
-	}
-}
+		run.SetReason(ctx, "resigned")
+		return attempter, nil
 
-func countFiles(dirPath string) (int, error) {
-	files, err := os.ReadDir(dirPath)
-	if err != nil {
-		return 0, err
+	case <-s.maintenance.Paused():
+		cancel()
+		s.logger.LogAttrs(ctx, slog.LevelInfo, "pausing", slog.Int64("epoch", info.Epoch))
+
+		err := s.coordinator.Release(ctx)
+		if err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelError, "can not release leadership", slog.String("msg", err.Error()))
+			run.SetReason(ctx, "paused, release failed: "+err.Error())
+			return s.dg.GetFailoverState(s.args)
+		}
+		run.SetReason(ctx, "paused")
+		return s.dg.GetMaintenanceState(s.args)
+
+	case err := <-failChan:
+		cancel()
+
+		if errors.Is(err, tasks.ErrStepDown) {
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "stepping down", slog.String("reason", err.Error()))
+			run.SetReason(ctx, err.Error())
+
+			err = s.coordinator.Release(ctx)
+			if err != nil {
+				s.logger.LogAttrs(ctx, slog.LevelError, "can not release leadership", slog.String("msg", err.Error()))
+			}
+			return s.dg.GetFailoverState(s.args)
+		}
+
+		s.logger.LogAttrs(ctx, slog.LevelError, "leader task failed", slog.String("msg", err.Error()))
+		run.SetReason(ctx, "leader task failed: "+err.Error())
+		return s.dg.GetStoppingState(s.args)
 	}
-	return len(files), nil
 }
 
-func cleanDirectory(dirPath string) error {
-	files, err := os.ReadDir(dirPath)
-	if err != nil {
-		return err
+// stopTasks cancels the leadership context and waits for the tasks to return
+// and finish their in-flight work, at most stopTimeout or, on shutdown, until
+// the shutdown deadline.
+func (s *LeaderState) stopTasks(ctx context.Context, cancel context.CancelFunc, wg *sync.WaitGroup) {
+	cancel()
+
+	var stopCtx context.Context
+	var stopCancel context.CancelFunc
+	if ctx.Err() != nil {
+		stopCtx, stopCancel = s.stopping.ShutdownContext(ctx)
+	} else {
+		stopCtx, stopCancel = context.WithTimeout(context.WithoutCancel(ctx), s.stopTimeout())
 	}
-	for _, file := range files {
-		err := os.Remove(filepath.Join(dirPath, file.Name()))
+	defer stopCancel()
+
+	for _, task := range s.tasks {
+		err := task.Stop(stopCtx)
 		if err != nil {
-			return err
+			s.logger.LogAttrs(ctx, slog.LevelWarn, "leader task did not stop cleanly", slog.String("msg", err.Error()))
 		}
 	}
-	return nil
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-stopCtx.Done():
+		s.logger.LogAttrs(ctx, slog.LevelWarn, "leader tasks still running after stop timeout")
+	case <-done:
+	}
 }
 
-func hostname() string {
-	host, err := os.Hostname()
-	if err != nil {
-		return "unknown"
+// stopTimeout is taskStopTimeout, or longer when the exec child may take
+// longer than that to exit.
+func (s *LeaderState) stopTimeout() time.Duration {
+	if len(s.args.Command) == 0 {
+		return taskStopTimeout
 	}
-	return host
+	return max(taskStopTimeout, s.args.ExecGracePeriod+taskStopMargin)
 }
@@ -0,0 +1,110 @@
+package states
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+)
+
+// leadingCoordinator holds leadership until it is released.
+type leadingCoordinator struct {
+	coordination.Coordinator
+
+	lost chan struct{}
+}
+
+func newLeadingCoordinator() *leadingCoordinator {
+	return &leadingCoordinator{lost: make(chan struct{})}
+}
+
+func (c *leadingCoordinator) Connected() bool {
+	return true
+}
+
+func (c *leadingCoordinator) Lost() <-chan struct{} {
+	return c.lost
+}
+
+func (c *leadingCoordinator) Epoch() int64 {
+	return 1
+}
+
+func (c *leadingCoordinator) Release(context.Context) error {
+	close(c.lost)
+	return nil
+}
+
+// racingCoordinator is a leadingCoordinator of a backend where candidates
+// race for the lock.
+type racingCoordinator struct {
+	*leadingCoordinator
+}
+
+func (c racingCoordinator) Racing() bool {
+	return true
+}
+
+// resign runs leader and asks it to resign until it returns the next state.
+// Requests made before the run are dropped, so one is not enough.
+func resign(t *testing.T, leader *LeaderState) run.AutomataState {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	next := make(chan run.AutomataState, 1)
+	go func() {
+		state, err := leader.Run(ctx)
+		if err != nil {
+			t.Errorf("run leader: %v", err)
+		}
+		next <- state
+	}()
+
+	for {
+		leader.Resign()
+		select {
+		case state := <-next:
+			return state
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+}
+
+func TestResignHoldsOffOnlyRacingBackends(t *testing.T) {
+	const leaseDuration = 15 * time.Second
+
+	tests := []struct {
+		name        string
+		coordinator coordination.Coordinator
+		wantHoldOff time.Duration
+	}{
+		{name: "queued", coordinator: newLeadingCoordinator()},
+		{name: "racing", coordinator: racingCoordinator{newLeadingCoordinator()}, wantHoldOff: leaseDuration},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dg := &testDepGraph{
+				clock:       extra.NewFakeClock(time.Unix(0, 0)),
+				coordinator: tt.coordinator,
+			}
+			leader, err := NewLeaderState(cmdargs.RunArgs{LeaseDuration: leaseDuration}, dg)
+			if err != nil {
+				t.Fatalf("new leader state: %v", err)
+			}
+
+			attempter, ok := resign(t, leader).(*AttempterState)
+			if !ok {
+				t.Fatal("resign did not lead to AttempterState")
+			}
+			if got := max(attempter.holdOffLeft(), 0); got != tt.wantHoldOff {
+				t.Fatalf("held off for %v, want %v", got, tt.wantHoldOff)
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package states
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+)
+
+func NewMaintenanceState(args cmdargs.RunArgs, dg DepGraph) (*MaintenanceState, error) {
+	logger, err := dg.GetLogger()
+	if err != nil {
+		return nil, fmt.Errorf("get logger: %w", err)
+	}
+
+	resumed := make(chan struct{})
+	close(resumed)
+
+	return &MaintenanceState{
+		logger:  logger.With("subsystem", "MaintenanceState"),
+		dg:      dg,
+		args:    args,
+		paused:  make(chan struct{}),
+		resumed: resumed,
+	}, nil
+}
+
+// MaintenanceState keeps the session with the coordinator but does not
+// contend for leadership until the node is resumed.
+type MaintenanceState struct {
+	logger *slog.Logger
+	dg     DepGraph
+	args   cmdargs.RunArgs
+
+	mu      sync.Mutex
+	paused  chan struct{}
+	resumed chan struct{}
+}
+
+// Pause makes AttempterState and LeaderState hand over to MaintenanceState.
+func (s *MaintenanceState) Pause() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.paused:
+		return
+	default:
+	}
+	close(s.paused)
+	s.resumed = make(chan struct{})
+}
+
+// Resume makes MaintenanceState return to AttempterState.
+func (s *MaintenanceState) Resume() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	select {
+	case <-s.resumed:
+		return
+	default:
+	}
+	close(s.resumed)
+	s.paused = make(chan struct{})
+}
+
+// Paused returns a channel that is closed while the node is paused.
+func (s *MaintenanceState) Paused() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.paused
+}
+
+// Resumed returns a channel that is closed while the node is not paused.
+func (s *MaintenanceState) Resumed() <-chan struct{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.resumed
+}
+
+func (s *MaintenanceState) String() string {
+	return "MaintenanceState"
+}
+
+func (s *MaintenanceState) Run(ctx context.Context) (run.AutomataState, error) {
+	s.logger.LogAttrs(ctx, slog.LevelWarn, "paused, not contending for leadership")
+
+	select {
+	case <-ctx.Done():
+		run.SetReason(ctx, "shutdown requested")
+		return s.dg.GetStoppingState(s.args)
+
+	case <-s.Resumed():
+		s.logger.LogAttrs(ctx, slog.LevelInfo, "resumed")
+		run.SetReason(ctx, "resumed")
+		return s.dg.GetAttempterState(s.args)
+	}
+}
@@ -4,48 +4,89 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"sync"
+	"time"
 
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
 	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
 )
 
+// defaultShutdownTimeout is used when the shutdown timeout is not set, e.g.
+// by library users.
+const defaultShutdownTimeout = 10 * time.Second
+
 func NewStoppingState(args cmdargs.RunArgs, dg DepGraph) (*StoppingState, error) {
 	logger, err := dg.GetLogger()
 	if err != nil {
 		return nil, fmt.Errorf("get logger: %w", err)
 	}
 
+	coordinator, err := dg.GetCoordinator(args)
+	if err != nil {
+		return nil, fmt.Errorf("get coordinator: %w", err)
+	}
+
+	clock, err := dg.GetClock()
+	if err != nil {
+		return nil, fmt.Errorf("get clock: %w", err)
+	}
+
+	timeout := args.ShutdownTimeout
+	if timeout <= 0 {
+		timeout = defaultShutdownTimeout
+	}
+
 	return &StoppingState{
-		logger: logger.With("subsystem", "StoppingState"),
-		dg:     dg,
-		args:   args,
+		logger:      logger.With("subsystem", "StoppingState"),
+		clock:       clock,
+		coordinator: coordinator,
+		timeout:     timeout,
+		dg:          dg,
+		args:        args,
 	}, nil
 }
 
+// StoppingState gives leadership up and closes the coordinator, so that the
+// other replicas do not have to wait for the session or lease to expire.
 type StoppingState struct {
-	logger *slog.Logger
-	dg     DepGraph
-	args   cmdargs.RunArgs
+	logger      *slog.Logger
+	clock       extra.Clock
+	coordinator coordination.Coordinator
+	timeout     time.Duration
+	dg          DepGraph
+	args        cmdargs.RunArgs
+
+	deadlineOnce sync.Once
+	deadline     time.Time
 }
 
 func (s *StoppingState) String() string {
 	return "StoppingState"
 }
 
+// ShutdownContext returns a context that outlives the cancellation of ctx
+// until the shutdown deadline. The deadline is set by the first call, the
+// leader waiting for its tasks and the release share it.
+func (s *StoppingState) ShutdownContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	s.deadlineOnce.Do(func() {
+		s.deadline = s.clock.Now().Add(s.timeout)
+	})
+	return context.WithTimeout(context.WithoutCancel(ctx), s.deadline.Sub(s.clock.Now()))
+}
+
 func (s *StoppingState) Run(ctx context.Context) (run.AutomataState, error) {
-	attempterState, err := s.dg.GetAttempterState(s.args)
-	if err != nil {
-		return nil, err
-	}
+	shutdownCtx, cancel := s.ShutdownContext(ctx)
+	defer cancel()
+
+	s.release(shutdownCtx)
 
-	leaderState, err := s.dg.GetLeaderState(s.args)
+	err := s.coordinator.Close()
 	if err != nil {
-		return nil, err
+		s.logger.LogAttrs(ctx, slog.LevelError, "can not close coordinator", slog.String("msg", err.Error()))
 	}
 
-	attempterState.Stop()
-	leaderState.Stop()
-
 	if ctx.Err() != nil {
 		s.logger.LogAttrs(ctx, slog.LevelWarn, "the server is stopped", slog.String("error", ctx.Err().Error()))
 
@@ -53,6 +94,32 @@ func (s *StoppingState) Run(ctx context.Context) (run.AutomataState, error) {
 	}
 
 	s.logger.LogAttrs(ctx, slog.LevelWarn, "the server is stopped")
+	run.SetReason(ctx, "stopped")
 
 	return nil, nil
 }
+
+// release gives leadership up when this replica still holds it.
+func (s *StoppingState) release(ctx context.Context) {
+	select {
+	case <-s.coordinator.Lost():
+		return
+	default:
+	}
+
+	s.logger.LogAttrs(ctx, slog.LevelInfo, "releasing leadership", slog.Int64("epoch", s.coordinator.Epoch()))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.coordinator.Release(ctx)
+	}()
+
+	select {
+	case <-ctx.Done():
+		s.logger.LogAttrs(ctx, slog.LevelWarn, "leadership not released before the shutdown deadline")
+	case err := <-done:
+		if err != nil {
+			s.logger.LogAttrs(ctx, slog.LevelError, "can not release leadership", slog.String("msg", err.Error()))
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package tasks
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+)
+
+var _ LeaderTask = &Exec{}
+
+// maxRestartDelay caps the restart backoff of the child. A child that ran at
+// least that long is restarted after the initial delay again.
+const maxRestartDelay = time.Minute
+
+func NewExec(command []string, gracePeriod, restartDelay time.Duration, clock extra.Clock, logger *slog.Logger) *Exec {
+	return &Exec{
+		clock:        clock,
+		logger:       logger.With("subsystem", "Exec"),
+		childLogger:  logger.With("subsystem", "child"),
+		command:      command,
+		gracePeriod:  gracePeriod,
+		restartDelay: restartDelay,
+	}
+}
+
+// Exec runs a command while the replica leads. The child runs in its own
+// process group, the group gets SIGTERM once leadership is lost and the child
+// SIGKILL if it is still running gracePeriod later. Processes left in the
+// group after the child exits are killed. A child that exits on its own is
+// restarted with an exponential backoff.
+type Exec struct {
+	clock        extra.Clock
+	logger       *slog.Logger
+	childLogger  *slog.Logger
+	command      []string
+	gracePeriod  time.Duration
+	restartDelay time.Duration
+
+	// running is held while Start supervises the child.
+	running sync.Mutex
+}
+
+func (e *Exec) Start(ctx context.Context, info LeadershipInfo) error {
+	e.running.Lock()
+	defer e.running.Unlock()
+
+	delay := e.restartDelay
+	for {
+		started := e.clock.Now()
+		ran, err := e.runChild(ctx, info)
+		if ctx.Err() != nil {
+			return nil
+		}
+		if !ran {
+			return err
+		}
+
+		if e.clock.Now().Sub(started) >= maxRestartDelay {
+			delay = e.restartDelay
+		}
+		e.logger.LogAttrs(ctx, slog.LevelWarn, "child exited, restarting",
+			slog.String("error", errString(err)),
+			slog.Duration("delay", delay))
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-e.clock.After(delay):
+		}
+		delay = min(delay*2, maxRestartDelay)
+	}
+}
+
+func (e *Exec) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		e.running.Lock()
+		defer e.running.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+// runChild starts the command and waits for it to exit, it reports whether
+// the child was started at all. Cancelling ctx sends SIGTERM to the process
+// group of the child and SIGKILL after the grace period.
+func (e *Exec) runChild(ctx context.Context, info LeadershipInfo) (bool, error) {
+	cmd := exec.CommandContext(ctx, e.command[0], e.command[1:]...)
+	cmd.Env = append(os.Environ(),
+		fmt.Sprintf("ELECTION_IDENTITY=%s", info.Identity),
+		fmt.Sprintf("ELECTION_EPOCH=%d", info.Epoch),
+	)
+	isolate(cmd)
+	cmd.Cancel = func() error {
+		return terminate(cmd)
+	}
+	cmd.WaitDelay = e.gracePeriod
+
+	stdout, stdoutDone := e.forward(ctx, "stdout")
+	stderr, stderrDone := e.forward(ctx, "stderr")
+	cmd.Stdout, cmd.Stderr = stdout, stderr
+	defer func() {
+		stdout.Close()
+		stderr.Close()
+		<-stdoutDone
+		<-stderrDone
+	}()
+
+	err := cmd.Start()
+	if err != nil {
+		return false, fmt.Errorf("start %s: %w", e.command[0], err)
+	}
+	e.logger.LogAttrs(ctx, slog.LevelInfo, "child started",
+		slog.String("command", e.command[0]),
+		slog.Int("pid", cmd.Process.Pid),
+		slog.Int64("epoch", info.Epoch))
+
+	err = cmd.Wait()
+	killErr := killGroup(cmd)
+	if killErr != nil {
+		e.logger.LogAttrs(ctx, slog.LevelWarn, "can not kill the process group of the child", slog.String("error", killErr.Error()))
+	}
+	e.logger.LogAttrs(ctx, slog.LevelInfo, "child exited",
+		slog.Int("pid", cmd.Process.Pid),
+		slog.String("state", cmd.ProcessState.String()))
+	return true, err
+}
+
+// forward logs every line written to the returned writer until it is closed.
+func (e *Exec) forward(ctx context.Context, stream string) (io.WriteCloser, <-chan struct{}) {
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		scanner := bufio.NewScanner(reader)
+		for scanner.Scan() {
+			e.childLogger.LogAttrs(context.WithoutCancel(ctx), slog.LevelInfo, scanner.Text(), slog.String("stream", stream))
+		}
+		// Keep draining so that the child never blocks on a long line.
+		_, _ = io.Copy(io.Discard, reader)
+	}()
+
+	return writer, done
+}
+
+func errString(err error) string {
+	if err == nil {
+		return "exit status 0"
+	}
+	return err.Error()
+}
@@ -0,0 +1,18 @@
+//go:build !unix
+
+package tasks
+
+import (
+	"os"
+	"os/exec"
+)
+
+func isolate(*exec.Cmd) {}
+
+func terminate(cmd *exec.Cmd) error {
+	return cmd.Process.Signal(os.Interrupt)
+}
+
+func killGroup(*exec.Cmd) error {
+	return nil
+}
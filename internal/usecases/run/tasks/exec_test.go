@@ -0,0 +1,75 @@
+//go:build unix
+
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+)
+
+func TestExecStopsGrandchildren(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "grandchild.pid")
+	// The shell waits for a grandchild that never exits on its own, only a
+	// signal to the whole group stops it.
+	script := "sleep 60 & echo $! > " + pidFile + "; wait"
+
+	e := NewExec([]string{"sh", "-c", script}, time.Second, time.Second, extra.NewClock(),
+		slog.New(slog.NewTextHandler(io.Discard, nil)))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- e.Start(ctx, LeadershipInfo{Identity: "replica", Epoch: 1}) }()
+
+	var pid int
+	deadline := time.Now().Add(5 * time.Second)
+	for pid == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("grandchild never started")
+		}
+		data, err := os.ReadFile(pidFile)
+		if err == nil && strings.HasSuffix(string(data), "\n") {
+			pid, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("start: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("exec did not stop")
+	}
+
+	if running(pid) {
+		_ = syscall.Kill(pid, syscall.SIGKILL)
+		t.Fatalf("grandchild %d survived the stop", pid)
+	}
+}
+
+// running reports whether pid is alive. The orphaned grandchild may linger as
+// a zombie until init reaps it, that counts as stopped.
+func running(pid int) bool {
+	if syscall.Kill(pid, 0) != nil {
+		return false
+	}
+	stat, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+	return len(fields) == 0 || fields[0] != "Z"
+}
@@ -0,0 +1,33 @@
+//go:build unix
+
+package tasks
+
+import (
+	"errors"
+	"os/exec"
+	"syscall"
+)
+
+// isolate starts the child in a process group of its own, so that signals
+// reach the processes it spawns as well.
+func isolate(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// terminate sends SIGTERM to the process group of the child.
+func terminate(cmd *exec.Cmd) error {
+	return signalGroup(cmd, syscall.SIGTERM)
+}
+
+// killGroup kills whatever is left in the process group of the child.
+func killGroup(cmd *exec.Cmd) error {
+	return signalGroup(cmd, syscall.SIGKILL)
+}
+
+func signalGroup(cmd *exec.Cmd, sig syscall.Signal) error {
+	err := syscall.Kill(-cmd.Process.Pid, sig)
+	if errors.Is(err, syscall.ESRCH) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,194 @@
+package tasks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+)
+
+var _ LeaderTask = &FileWriter{}
+
+const (
+	layout = "2006-01-02_15-04-05"
+)
+
+// epochPattern extracts the leadership epoch from the names of leader files.
+var epochPattern = regexp.MustCompile(`_epoch-(\d+)\.txt$`)
+
+func NewFileWriter(fileDir string, storageCapacity int, period time.Duration, clock extra.Clock, logger *slog.Logger) *FileWriter {
+	return &FileWriter{
+		logger:          logger.With("subsystem", "FileWriter"),
+		clock:           clock,
+		fileDir:         fileDir,
+		storageCapacity: storageCapacity,
+		period:          period,
+	}
+}
+
+// FileWriter writes a file into fileDir every period and keeps at most
+// storageCapacity files there. Both limits can be changed while it runs.
+type FileWriter struct {
+	logger  *slog.Logger
+	clock   extra.Clock
+	fileDir string
+
+	mu              sync.Mutex
+	storageCapacity int
+	period          time.Duration
+	ticker          extra.Ticker
+
+	// writing is held for the duration of every single write.
+	writing sync.Mutex
+}
+
+// SetPeriod changes the write period, a running ticker is reset to it.
+func (w *FileWriter) SetPeriod(period time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.period = period
+	if w.ticker != nil {
+		w.ticker.Reset(period)
+	}
+}
+
+// SetStorageCapacity changes the number of files kept in the directory, it
+// applies from the next write on.
+func (w *FileWriter) SetStorageCapacity(storageCapacity int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.storageCapacity = storageCapacity
+}
+
+func (w *FileWriter) Start(ctx context.Context, info LeadershipInfo) error {
+	w.mu.Lock()
+	ticker := w.clock.NewTicker(w.period)
+	w.ticker = ticker
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.ticker = nil
+		w.mu.Unlock()
+		ticker.Stop()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.Chan():
+		}
+
+		err := w.writeFile(ctx, info)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (w *FileWriter) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.writing.Lock()
+		defer w.writing.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
+
+func (w *FileWriter) writeFile(ctx context.Context, info LeadershipInfo) error {
+	w.writing.Lock()
+	defer w.writing.Unlock()
+
+	fileCount, newestEpoch, err := scanFiles(w.fileDir)
+	if err != nil {
+		return err
+	}
+
+	if newestEpoch > info.Epoch {
+		return fmt.Errorf("%w: epoch %d in %s, own %d", ErrStepDown, newestEpoch, w.fileDir, info.Epoch)
+	}
+
+	w.mu.Lock()
+	storageCapacity := w.storageCapacity
+	w.mu.Unlock()
+
+	if fileCount >= storageCapacity {
+		err := cleanDirectory(w.fileDir)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := w.clock.Now()
+	fileName := fmt.Sprintf("%s_%s_epoch-%d.txt", extra.Hostname(), now.Format(layout), info.Epoch)
+	filePath := filepath.Join(w.fileDir, fileName)
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(file, "epoch: %d\nhostname: %s\ntime: %s\n", info.Epoch, extra.Hostname(), now.Format(time.RFC3339Nano))
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	w.logger.LogAttrs(ctx, slog.LevelInfo, "created new file",
+		slog.String("filePath", filePath),
+		slog.Int64("epoch", info.Epoch))
+
+	return file.Close()
+}
+
+// scanFiles returns the number of files in dirPath and the newest leadership
+// epoch found in their names.
+func scanFiles(dirPath string) (int, int64, error) {
+	files, err := os.ReadDir(dirPath)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var newest int64
+	for _, file := range files {
+		match := epochPattern.FindStringSubmatch(file.Name())
+		if match == nil {
+			continue
+		}
+
+		epoch, err := strconv.ParseInt(match[1], 10, 64)
+		if err == nil && epoch > newest {
+			newest = epoch
+		}
+	}
+	return len(files), newest, nil
+}
+
+func cleanDirectory(dirPath string) error {
+	files, err := os.ReadDir(dirPath)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		err := os.Remove(filepath.Join(dirPath, file.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
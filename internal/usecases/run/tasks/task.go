@@ -0,0 +1,27 @@
+package tasks
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStepDown is wrapped by task errors that ask the replica to give
+// leadership up instead of stopping, e.g. when a newer leader is observed.
+var ErrStepDown = errors.New("leader task requested to step down")
+
+// LeadershipInfo describes the leadership a task runs under.
+type LeadershipInfo struct {
+	Identity string
+	Epoch    int64
+	Since    time.Time
+}
+
+// LeaderTask is the work a replica does while it is the leader.
+type LeaderTask interface {
+	// Start does the work until ctx is cancelled, which happens the moment
+	// leadership is lost, and returns nil then. An error ends leadership.
+	Start(ctx context.Context, info LeadershipInfo) error
+	// Stop waits for in-flight work of a cancelled Start to finish within ctx.
+	Stop(ctx context.Context) error
+}
@@ -0,0 +1,130 @@
+package run
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Transition is an allowed change from one state to another. An empty From is
+// the start of the machine and an empty To is its end.
+type Transition struct {
+	From        string
+	To          string
+	Description string
+}
+
+// IllegalTransitionError is returned by LoopRunner when a state hands over to
+// a state the transition table does not allow.
+type IllegalTransitionError struct {
+	From string
+	To   string
+}
+
+func (e *IllegalTransitionError) Error() string {
+	return fmt.Sprintf("illegal transition from %s to %s", orEdge(e.From, "start"), orEdge(e.To, "end"))
+}
+
+func NewTransitionTable(transitions ...Transition) *TransitionTable {
+	allowed := make(map[string]map[string]bool)
+	for _, t := range transitions {
+		if allowed[t.From] == nil {
+			allowed[t.From] = make(map[string]bool)
+		}
+		allowed[t.From][t.To] = true
+	}
+
+	return &TransitionTable{
+		transitions: transitions,
+		allowed:     allowed,
+	}
+}
+
+// TransitionTable declares the transitions the state machine may take.
+type TransitionTable struct {
+	transitions []Transition
+	allowed     map[string]map[string]bool
+}
+
+// Transitions is the table of the election state machine.
+var Transitions = NewTransitionTable(
+	Transition{From: "", To: "InitState"},
+	Transition{From: "InitState", To: "AttempterState", Description: "connected to the coordinator"},
+	Transition{From: "InitState", To: "FailoverState", Description: "coordinator is unavailable"},
+	Transition{From: "InitState", To: "StoppingState", Description: "shutdown requested"},
+	Transition{From: "AttempterState", To: "LeaderState", Description: "leadership acquired"},
+	Transition{From: "AttempterState", To: "FailoverState", Description: "coordinator is unavailable"},
+	Transition{From: "AttempterState", To: "StoppingState", Description: "shutdown requested"},
+	Transition{From: "AttempterState", To: "MaintenanceState", Description: "paused"},
+	Transition{From: "LeaderState", To: "AttempterState", Description: "leadership resigned"},
+	Transition{From: "LeaderState", To: "FailoverState", Description: "leadership lost or stale epoch"},
+	Transition{From: "LeaderState", To: "StoppingState", Description: "shutdown requested or leader task failed"},
+	Transition{From: "LeaderState", To: "MaintenanceState", Description: "paused"},
+	Transition{From: "FailoverState", To: "AttempterState", Description: "reconnected to the coordinator"},
+	Transition{From: "FailoverState", To: "StoppingState", Description: "shutdown requested or retries exhausted"},
+	Transition{From: "MaintenanceState", To: "AttempterState", Description: "resumed"},
+	Transition{From: "MaintenanceState", To: "StoppingState", Description: "shutdown requested"},
+	Transition{From: "StoppingState", To: "", Description: "resources released"},
+)
+
+// Allowed reports whether the machine may go from one state to another.
+func (t *TransitionTable) Allowed(from, to string) bool {
+	return t.allowed[from][to]
+}
+
+// Check returns an *IllegalTransitionError if the transition is not allowed.
+func (t *TransitionTable) Check(from, to string) error {
+	if !t.Allowed(from, to) {
+		return &IllegalTransitionError{From: from, To: to}
+	}
+	return nil
+}
+
+// Mermaid renders the table as a Mermaid state diagram.
+func (t *TransitionTable) Mermaid() string {
+	var b strings.Builder
+	b.WriteString("stateDiagram-v2\n\n")
+	for _, tr := range t.transitions {
+		fmt.Fprintf(&b, "%s --> %s", orEdge(diagramName(tr.From), "[*]"), orEdge(diagramName(tr.To), "[*]"))
+		if tr.Description != "" {
+			fmt.Fprintf(&b, " : %s", tr.Description)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// DOT renders the table as a Graphviz digraph.
+func (t *TransitionTable) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph states {\n")
+	b.WriteString("\tstart [shape=point];\n")
+	b.WriteString("\tend [shape=doublecircle, label=\"\", width=0.2];\n")
+	for _, tr := range t.transitions {
+		fmt.Fprintf(&b, "\t%s -> %s", orEdge(quote(diagramName(tr.From)), "start"), orEdge(quote(diagramName(tr.To)), "end"))
+		if tr.Description != "" {
+			fmt.Fprintf(&b, " [label=%q]", tr.Description)
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// diagramName drops the State suffix, which only adds noise to diagrams.
+func diagramName(state string) string {
+	return strings.TrimSuffix(state, "State")
+}
+
+func quote(name string) string {
+	if name == "" {
+		return ""
+	}
+	return fmt.Sprintf("%q", name)
+}
+
+func orEdge(name, edge string) string {
+	if name == "" {
+		return edge
+	}
+	return name
+}
@@ -0,0 +1,60 @@
+package simulate
+
+import (
+	"cmp"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// Interval is a stay of a replica in LeaderState, the times are since the
+// start of the simulation.
+type Interval struct {
+	Replica string
+	Epoch   int64
+	Start   time.Duration
+	End     time.Duration
+}
+
+func (i Interval) String() string {
+	return fmt.Sprintf("%s epoch %d [%v, %v]", i.Replica, i.Epoch, i.Start, i.End)
+}
+
+// Violation is a pair of leadership intervals of different replicas that
+// overlap by more than the tolerance.
+type Violation struct {
+	First   Interval
+	Second  Interval
+	Overlap time.Duration
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("split brain for %v: %v and %v", v.Overlap, v.First, v.Second)
+}
+
+// Check returns every pair of intervals of different replicas overlapping by
+// more than tolerance, ordered by the start of the first interval.
+func Check(intervals []Interval, tolerance time.Duration) []Violation {
+	sorted := slices.Clone(intervals)
+	slices.SortStableFunc(sorted, func(a, b Interval) int {
+		return cmp.Compare(a.Start, b.Start)
+	})
+
+	var violations []Violation
+	for i, first := range sorted {
+		for _, second := range sorted[i+1:] {
+			if second.Start >= first.End {
+				break
+			}
+			if second.Replica == first.Replica {
+				continue
+			}
+
+			overlap := min(first.End, second.End) - second.Start
+			if overlap > tolerance {
+				violations = append(violations, Violation{First: first, Second: second, Overlap: overlap})
+			}
+		}
+	}
+	return violations
+}
@@ -0,0 +1,415 @@
+package simulate
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/coordination/zookeeper"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+)
+
+var (
+	errPartitioned  = errors.New("partitioned from the cluster")
+	errNotConnected = errors.New("not connected")
+	errExpired      = errors.New("session expired")
+)
+
+// cluster is an in-memory lock service with ZooKeeper-like sessions on the
+// fake clock. The server expires the session of a partitioned replica after
+// the session timeout on the cluster clock, while the replica itself gives
+// leadership up once it has been cut off for zookeeper.SuspendFraction of it
+// on its own clock, so that a correct replica steps down before anyone else
+// can be elected unless its clock runs too slow.
+//
+// The lock is only granted by tick, to the first waiting replica in the order
+// they joined, so the election does not depend on goroutine scheduling.
+type cluster struct {
+	clock   *extra.FakeClock
+	timeout time.Duration
+
+	// activity counts calls into the cluster, the driver waits for it to
+	// settle before moving the clock.
+	activity atomic.Int64
+
+	mu       sync.Mutex
+	epoch    int64
+	session  int64
+	holder   *member
+	members  []*member
+	changed  chan struct{}
+	diskTime map[string]time.Duration
+}
+
+func newCluster(clock *extra.FakeClock, timeout time.Duration) *cluster {
+	return &cluster{
+		clock:    clock,
+		timeout:  timeout,
+		changed:  make(chan struct{}),
+		diskTime: make(map[string]time.Duration),
+	}
+}
+
+// member is the view of one replica on the cluster, it implements
+// coordination.Coordinator.
+type member struct {
+	cluster  *cluster
+	identity string
+
+	// clock is the clock of the replica, it runs at skewRate of the cluster
+	// clock until skewUntil and then catches up by lag.
+	clock     *extra.FakeClock
+	skewUntil time.Time
+	skewRate  float64
+	lag       time.Duration
+
+	// Server side: the live session, 0 when there is none, and whether an
+	// Acquire is waiting for the lock.
+	session int64
+	waiting bool
+
+	// Client side: whether the replica believes it is connected, its
+	// leadership and the pending notice of a server side expiry.
+	connected bool
+	epoch     int64
+	lost      chan struct{}
+	noticeAt  time.Time
+
+	// partitionedAt is on the cluster clock, cutAt the same moment on the
+	// clock of the replica.
+	partitioned   bool
+	partitionedAt time.Time
+	cutAt         time.Time
+	healAt        time.Time
+}
+
+var _ coordination.Coordinator = &member{}
+
+func (c *cluster) join(identity string, clock *extra.FakeClock) *member {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	lost := make(chan struct{})
+	close(lost)
+
+	m := &member{cluster: c, identity: identity, clock: clock, lost: lost}
+	c.members = append(c.members, m)
+	return m
+}
+
+// notify wakes every Acquire up, c.mu must be held.
+func (c *cluster) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// partition cuts m off until now+d.
+func (c *cluster) partition(m *member, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	if !m.partitioned {
+		m.partitioned, m.partitionedAt, m.cutAt = true, now, m.clock.Now()
+	}
+	m.healAt = maxTime(m.healAt, now.Add(d))
+	c.notify()
+}
+
+// expire ends the session of m on the server, m notices it after delay.
+func (c *cluster) expire(m *member, delay time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m.session == 0 {
+		return
+	}
+	c.expireSession(m)
+	m.noticeAt = c.clock.Now().Add(delay)
+}
+
+// expireSession frees the lock of m on the server, c.mu must be held.
+func (c *cluster) expireSession(m *member) {
+	m.session = 0
+	if c.holder == m {
+		c.holder = nil
+	}
+	c.notify()
+}
+
+// skew makes the clock of m run at rate until now+d.
+func (c *cluster) skew(m *member, d time.Duration, rate float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	m.skewUntil, m.skewRate = c.clock.Now().Add(d), rate
+}
+
+// advance moves the cluster clock by d and the clock of every replica by its
+// share of d: a skewed clock falls behind and catches up once the skew ends.
+func (c *cluster) advance(d time.Duration) {
+	c.clock.Advance(d)
+	now := c.clock.Now()
+
+	c.mu.Lock()
+	members := slices.Clone(c.members)
+	steps := make([]time.Duration, len(members))
+	for i, m := range members {
+		if !now.After(m.skewUntil) {
+			steps[i] = time.Duration(float64(d) * m.skewRate)
+			m.lag += d - steps[i]
+		} else {
+			steps[i], m.lag = d+m.lag, 0
+		}
+	}
+	c.mu.Unlock()
+
+	for i, m := range members {
+		m.clock.Advance(steps[i])
+	}
+}
+
+// setDiskLatency makes every write of the disk task of identity take d.
+func (c *cluster) setDiskLatency(identity string, d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.diskTime[identity] = d
+}
+
+func (c *cluster) diskLatency(identity string) time.Duration {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.diskTime[identity]
+}
+
+// tick applies the consequences of the time that has passed: healed
+// partitions, suspended replicas, expired sessions and noticed expiries. Then
+// it grants a free lock. A replica measures its cut off time on its own
+// clock, the server on the cluster clock.
+func (c *cluster) tick() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := c.clock.Now()
+	suspendAfter := time.Duration(float64(c.timeout) * zookeeper.SuspendFraction)
+
+	for _, m := range c.members {
+		if m.partitioned {
+			if m.clock.Now().Sub(m.cutAt) >= suspendAfter && m.connected {
+				m.connected = false
+				m.loseLeadership()
+			}
+			if minTime(now, m.healAt).Sub(m.partitionedAt) >= c.timeout && m.session != 0 {
+				c.expireSession(m)
+			}
+			if !now.Before(m.healAt) {
+				m.partitioned = false
+				// The client reconnects by itself while the session lives.
+				m.connected = m.session != 0
+				c.notify()
+			}
+		}
+
+		if !m.noticeAt.IsZero() && !now.Before(m.noticeAt) {
+			m.noticeAt = time.Time{}
+			m.connected = false
+			m.loseLeadership()
+			c.notify()
+		}
+	}
+
+	if c.holder != nil {
+		return
+	}
+	for _, m := range c.members {
+		if m.waiting && m.connected && !m.partitioned && m.session != 0 {
+			c.epoch++
+			c.holder, m.epoch = m, c.epoch
+			m.lost = make(chan struct{})
+			c.notify()
+			return
+		}
+	}
+}
+
+// loseLeadership closes lost once, c.mu must be held.
+func (m *member) loseLeadership() {
+	select {
+	case <-m.lost:
+	default:
+		close(m.lost)
+	}
+}
+
+// leading reports whether m holds the lock and still believes so, c.mu must
+// be held.
+func (m *member) leading() bool {
+	if m.cluster.holder != m {
+		return false
+	}
+	select {
+	case <-m.lost:
+		return false
+	default:
+		return true
+	}
+}
+
+func (m *member) Connect(context.Context) error {
+	c := m.cluster
+	c.activity.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m.partitioned {
+		return errPartitioned
+	}
+	if m.session == 0 {
+		c.session++
+		m.session = c.session
+		m.noticeAt = time.Time{}
+	}
+	m.connected = true
+	return nil
+}
+
+func (m *member) Connected() bool {
+	c := m.cluster
+	c.activity.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return m.connected
+}
+
+func (m *member) Acquire(ctx context.Context) error {
+	c := m.cluster
+
+	c.mu.Lock()
+	if c.holder == m && !m.leading() {
+		// The replica gave leadership up while its session survived, it
+		// campaigns again like everyone else.
+		c.holder = nil
+		c.notify()
+	}
+	c.mu.Unlock()
+
+	for {
+		c.activity.Add(1)
+
+		c.mu.Lock()
+		switch {
+		case m.partitioned || !m.connected:
+			m.waiting = false
+			c.mu.Unlock()
+			return errNotConnected
+		case m.session == 0:
+			m.waiting = false
+			c.mu.Unlock()
+			return errExpired
+		case c.holder == m:
+			m.waiting = false
+			c.mu.Unlock()
+			return nil
+		}
+		m.waiting = true
+		changed := c.changed
+		c.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			c.mu.Lock()
+			defer c.mu.Unlock()
+
+			m.waiting = false
+			// The lock may have been granted in the meantime.
+			if c.holder == m {
+				return nil
+			}
+			return ctx.Err()
+		case <-changed:
+		}
+	}
+}
+
+func (m *member) Lost() <-chan struct{} {
+	c := m.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return m.lost
+}
+
+func (m *member) Epoch() int64 {
+	c := m.cluster
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return m.epoch
+}
+
+func (m *member) Leader(context.Context) (string, error) {
+	c := m.cluster
+	c.activity.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if m.partitioned || !m.connected {
+		return "", errNotConnected
+	}
+	if c.holder == nil {
+		return "", nil
+	}
+	return c.holder.identity, nil
+}
+
+func (m *member) Release(context.Context) error {
+	c := m.cluster
+	c.activity.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m.loseLeadership()
+	if m.partitioned {
+		return errPartitioned
+	}
+	if c.holder == m {
+		c.holder = nil
+		c.notify()
+	}
+	return nil
+}
+
+func (m *member) Close() error {
+	c := m.cluster
+	c.activity.Add(1)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	m.loseLeadership()
+	m.connected = false
+	m.waiting = false
+	if !m.partitioned && m.session != 0 {
+		c.expireSession(m)
+	}
+	return nil
+}
+
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,97 @@
+package simulate
+
+import (
+	"fmt"
+	"math"
+	"math/rand/v2"
+	"time"
+)
+
+// FaultKind is what goes wrong in a Fault.
+type FaultKind int
+
+const (
+	// FaultPartition cuts a replica off the cluster for Duration.
+	FaultPartition FaultKind = iota
+	// FaultExpire expires the session of a replica on the server, the
+	// replica notices it after Duration.
+	FaultExpire
+	// FaultClockSkew makes the clock of a replica run at Rate of the real
+	// speed for Duration, then it catches up at once, like a drifting clock
+	// stepped by NTP.
+	FaultClockSkew
+	// FaultSlowDisk makes every write of a replica take Duration from now on.
+	FaultSlowDisk
+)
+
+func (k FaultKind) String() string {
+	switch k {
+	case FaultPartition:
+		return "partition"
+	case FaultExpire:
+		return "expire"
+	case FaultClockSkew:
+		return "clock-skew"
+	case FaultSlowDisk:
+		return "slow-disk"
+	default:
+		return fmt.Sprintf("FaultKind(%d)", int(k))
+	}
+}
+
+// Fault is injected At the given time since the start of the simulation.
+type Fault struct {
+	At       time.Duration
+	Kind     FaultKind
+	Replica  int
+	Duration time.Duration
+	// Rate is the speed of the skewed clock in FaultClockSkew.
+	Rate float64
+}
+
+func (f Fault) String() string {
+	if f.Kind == FaultClockSkew {
+		return fmt.Sprintf("%v %v replica-%d at %.2fx for %v", f.At, f.Kind, f.Replica, f.Rate, f.Duration)
+	}
+	return fmt.Sprintf("%v %v replica-%d for %v", f.At, f.Kind, f.Replica, f.Duration)
+}
+
+// meanFaultGap is the mean time between two faults.
+const meanFaultGap = 5 * time.Second
+
+// minSkewRate is the slowest a skewed clock runs. The timeouts only keep the
+// leaderships apart while the clocks drift by a bounded amount, a clock that
+// loses more than a third would keep a partitioned leader past the expiry of
+// its session.
+const minSkewRate = 0.9
+
+// schedule draws the faults of cfg from its seed, the same seed always gives
+// the same schedule.
+func schedule(cfg Config) []Fault {
+	rnd := rand.New(rand.NewPCG(cfg.Seed, cfg.Seed))
+	timeout := cfg.SessionTimeout
+
+	var faults []Fault
+	at := time.Duration(0)
+	for {
+		at += time.Duration(rnd.ExpFloat64() * float64(meanFaultGap))
+		if at >= cfg.Duration {
+			return faults
+		}
+
+		f := Fault{At: at, Kind: FaultKind(rnd.IntN(4)), Replica: rnd.IntN(cfg.Replicas)}
+		switch f.Kind {
+		case FaultPartition:
+			f.Duration = timeout/10 + time.Duration(rnd.Int64N(int64(3*timeout-timeout/10)))
+		case FaultExpire:
+			f.Duration = time.Duration(rnd.Int64N(int64(timeout/3) + 1))
+		case FaultClockSkew:
+			f.Duration = timeout + time.Duration(rnd.Int64N(int64(2*timeout)))
+			f.Rate = math.Round((minSkewRate+(1-minSkewRate)*rnd.Float64())*100) / 100
+		case FaultSlowDisk:
+			f.Duration = time.Duration(rnd.Int64N(int64(cfg.MaxDiskLatency) + 1))
+		}
+		f.At, f.Duration = f.At.Round(time.Millisecond), f.Duration.Round(time.Millisecond)
+		faults = append(faults, f)
+	}
+}
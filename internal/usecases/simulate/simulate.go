@@ -0,0 +1,424 @@
+// Package simulate runs several replicas of the election state machine in one
+// process against an in-memory coordinator and fake clocks, one per replica,
+// injects faults drawn from a seed and checks that two replicas never lead at
+// once for longer than the session timeout.
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/depgraph"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/backoff"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/states"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/tasks"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Defaults of the zero fields of Config.
+const (
+	defaultReplicas       = 3
+	defaultDuration       = 5 * time.Minute
+	defaultStep           = 100 * time.Millisecond
+	defaultSessionTimeout = 2 * time.Second
+)
+
+// settleRounds: the driver moves the clocks once the replicas have neither
+// called into the cluster nor armed a timer for that many yields in a row.
+const settleRounds = 50
+
+// shutdownTimeout bounds the simulated time the replicas get to stop.
+const shutdownTimeout = time.Minute
+
+// Config describes a simulation, the zero fields take the defaults.
+type Config struct {
+	// Seed draws the faults, a failed run is replayed with the same seed.
+	Seed uint64
+	// Faults replace the faults drawn from Seed when set.
+	Faults   []Fault
+	Replicas int
+	// Duration is the simulated time the replicas run for, Step is how far
+	// the clock moves between two looks at the replicas.
+	Duration time.Duration
+	Step     time.Duration
+	// SessionTimeout is also the tolerated overlap of two leaderships.
+	SessionTimeout time.Duration
+	// MaxDiskLatency caps the slow disk faults, defaults to half the session
+	// timeout.
+	MaxDiskLatency time.Duration
+	// Logger receives the logs of every replica, nothing is logged when nil.
+	Logger *slog.Logger
+}
+
+func (cfg Config) withDefaults() Config {
+	if cfg.Replicas <= 0 {
+		cfg.Replicas = defaultReplicas
+	}
+	if cfg.Duration <= 0 {
+		cfg.Duration = defaultDuration
+	}
+	if cfg.Step <= 0 {
+		cfg.Step = defaultStep
+	}
+	if cfg.SessionTimeout <= 0 {
+		cfg.SessionTimeout = defaultSessionTimeout
+	}
+	if cfg.MaxDiskLatency <= 0 {
+		cfg.MaxDiskLatency = cfg.SessionTimeout / 2
+	}
+	if cfg.Logger == nil {
+		cfg.Logger = slog.New(slog.NewTextHandler(io.Discard, nil))
+	}
+	return cfg
+}
+
+// Report is the outcome of a simulation.
+type Report struct {
+	Seed       uint64
+	Faults     []Fault
+	Intervals  []Interval
+	Violations []Violation
+}
+
+// Err returns an error naming the seed when the checker found violations.
+func (r Report) Err() error {
+	if len(r.Violations) == 0 {
+		return nil
+	}
+	return fmt.Errorf("%d split brain violations with seed %d, first: %v", len(r.Violations), r.Seed, r.Violations[0])
+}
+
+// Run simulates cfg.Duration of the replicas and checks the leadership
+// intervals. An error means the replicas could not be set up or did not stop,
+// violations are reported in Report.
+func Run(ctx context.Context, cfg Config) (Report, error) {
+	cfg = cfg.withDefaults()
+	defer singleThreaded()()
+
+	clock := extra.NewFakeClock(time.Unix(0, 0).UTC())
+	s := &simulation{
+		cfg:     cfg,
+		clock:   clock,
+		cluster: newCluster(clock, cfg.SessionTimeout),
+		start:   clock.Now(),
+		open:    make(map[string]Interval),
+	}
+	report := Report{Seed: cfg.Seed, Faults: cfg.Faults}
+	if report.Faults == nil {
+		report.Faults = schedule(cfg)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := range cfg.Replicas {
+		start, err := s.replica(i)
+		if err != nil {
+			return report, fmt.Errorf("set up replica-%d: %w", i, err)
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start(runCtx)
+		}()
+	}
+
+	s.settle()
+	faults := report.Faults
+	for s.elapsed() < cfg.Duration && ctx.Err() == nil {
+		for len(faults) > 0 && faults[0].At <= s.elapsed() {
+			s.inject(faults[0])
+			faults = faults[1:]
+		}
+		s.advance(cfg.Step)
+	}
+
+	cancel()
+	err := s.drain(&wg)
+	if err != nil {
+		return report, err
+	}
+
+	report.Intervals = s.closeIntervals()
+	report.Violations = Check(report.Intervals, cfg.SessionTimeout)
+	return report, nil
+}
+
+// simulation drives the clocks and records the leadership intervals on the
+// cluster clock.
+type simulation struct {
+	cfg     Config
+	clock   *extra.FakeClock
+	cluster *cluster
+	start   time.Time
+	members []*member
+
+	mu        sync.Mutex
+	open      map[string]Interval
+	intervals []Interval
+}
+
+// replica builds the state machine of the replica i and returns the function
+// running it.
+func (s *simulation) replica(i int) (func(ctx context.Context), error) {
+	identity := fmt.Sprintf("replica-%d", i)
+	logger := s.cfg.Logger.With("replica", identity)
+	clock := extra.NewFakeClock(s.start)
+	m := s.cluster.join(identity, clock)
+	s.members = append(s.members, m)
+
+	registry := prometheus.NewRegistry()
+	runner := run.NewLoopRunner(logger, clock, registry)
+
+	dg := depgraph.New().
+		WithLogger(logger).
+		WithClock(clock).
+		WithRegistry(registry).
+		WithCoordinator(m).
+		WithRunner(runner).
+		WithLeaderTasks(&diskWriter{cluster: s.cluster, identity: identity, clock: clock, period: s.cfg.SessionTimeout / 2})
+
+	args := cmdargs.RunArgs{
+		Identity:             identity,
+		SessionTimeout:       s.cfg.SessionTimeout,
+		FailoverBackoff:      backoff.StrategyExponential,
+		FailoverInitialDelay: s.cfg.SessionTimeout / 4,
+		FailoverMaxDelay:     s.cfg.SessionTimeout,
+	}
+
+	runner.AddHook(func(_ context.Context, from, to run.AutomataState) {
+		s.cluster.activity.Add(1)
+
+		_, wasLeader := from.(*states.LeaderState)
+		_, isLeader := to.(*states.LeaderState)
+		switch {
+		case isLeader && !wasLeader:
+			s.startInterval(identity, m.Epoch())
+		case wasLeader && !isLeader:
+			s.endInterval(identity)
+		}
+	})
+
+	first, err := dg.GetInitState(args)
+	if err != nil {
+		return nil, fmt.Errorf("get first state: %w", err)
+	}
+
+	return func(ctx context.Context) {
+		err := runner.Run(ctx, first)
+		if err != nil {
+			logger.LogAttrs(ctx, slog.LevelError, "state machine failed", slog.String("msg", err.Error()))
+		}
+	}, nil
+}
+
+func (s *simulation) elapsed() time.Duration {
+	return s.clock.Now().Sub(s.start)
+}
+
+func (s *simulation) inject(f Fault) {
+	m := s.members[f.Replica]
+	s.cfg.Logger.LogAttrs(context.Background(), slog.LevelInfo, "injecting fault", slog.String("fault", f.String()))
+
+	switch f.Kind {
+	case FaultPartition:
+		s.cluster.partition(m, f.Duration)
+	case FaultExpire:
+		s.cluster.expire(m, f.Duration)
+	case FaultClockSkew:
+		s.cluster.skew(m, f.Duration, f.Rate)
+	case FaultSlowDisk:
+		s.cluster.setDiskLatency(m.identity, f.Duration)
+	}
+}
+
+// advance moves the clocks by d in one go and waits for the replicas to
+// react. The replicas handle their timers before the cluster applies the
+// time that has passed, so the two never race.
+func (s *simulation) advance(d time.Duration) {
+	s.cluster.advance(d)
+	s.settle()
+	s.cluster.tick()
+	s.settle()
+}
+
+// settle waits until the replicas block on their clocks or on the
+// coordinator. It yields to them instead of sleeping, and with a single P a
+// yield runs every goroutine that is ready, so the simulated time alone
+// decides what the replicas see and a seed replays the same run.
+func (s *simulation) settle() {
+	last := s.progress()
+	for quiet := 0; quiet < settleRounds; {
+		runtime.Gosched()
+
+		n := s.progress()
+		if n == last {
+			quiet++
+		} else {
+			quiet, last = 0, n
+		}
+	}
+}
+
+// progress counts what the replicas do: calls into the cluster and armed
+// timers.
+func (s *simulation) progress() uint64 {
+	progress := uint64(s.cluster.activity.Load())
+	for _, m := range s.members {
+		progress += m.clock.Armed()
+	}
+	return progress
+}
+
+// serial counts the running simulations, procs is the GOMAXPROCS to restore
+// once the last one is done.
+var serial struct {
+	sync.Mutex
+	runs  int
+	procs int
+}
+
+// singleThreaded runs every goroutine on a single P until the returned
+// function is called, see settle.
+func singleThreaded() func() {
+	serial.Lock()
+	defer serial.Unlock()
+
+	if serial.runs == 0 {
+		serial.procs = runtime.GOMAXPROCS(1)
+	}
+	serial.runs++
+
+	return func() {
+		serial.Lock()
+		defer serial.Unlock()
+
+		serial.runs--
+		if serial.runs == 0 {
+			runtime.GOMAXPROCS(serial.procs)
+		}
+	}
+}
+
+// drain keeps the clock moving until every replica has stopped, since
+// in-flight writes wait on it.
+func (s *simulation) drain(wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	deadline := s.elapsed() + shutdownTimeout
+	for {
+		select {
+		case <-done:
+			return nil
+		default:
+		}
+
+		if s.elapsed() >= deadline {
+			return fmt.Errorf("replicas did not stop within %v", shutdownTimeout)
+		}
+		s.advance(s.cfg.Step)
+	}
+}
+
+func (s *simulation) startInterval(identity string, epoch int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.open[identity] = Interval{Replica: identity, Epoch: epoch, Start: s.elapsed()}
+}
+
+func (s *simulation) endInterval(identity string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	interval, ok := s.open[identity]
+	if !ok {
+		return
+	}
+	delete(s.open, identity)
+
+	interval.End = s.elapsed()
+	s.intervals = append(s.intervals, interval)
+}
+
+// closeIntervals ends the intervals still open and returns all of them.
+func (s *simulation) closeIntervals() []Interval {
+	for _, m := range s.members {
+		s.endInterval(m.identity)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.intervals
+}
+
+// diskWriter is the leader task of the replicas: a write every period that
+// takes the disk latency of the replica and is waited for on Stop.
+type diskWriter struct {
+	cluster  *cluster
+	identity string
+	clock    extra.Clock
+	period   time.Duration
+
+	writing sync.Mutex
+}
+
+var _ tasks.LeaderTask = &diskWriter{}
+
+func (w *diskWriter) Start(ctx context.Context, _ tasks.LeadershipInfo) error {
+	ticker := w.clock.NewTicker(w.period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.Chan():
+		}
+
+		// select picks at random when the tick and the cancellation race.
+		if ctx.Err() != nil {
+			return nil
+		}
+		w.write()
+	}
+}
+
+func (w *diskWriter) write() {
+	w.writing.Lock()
+	defer w.writing.Unlock()
+
+	w.cluster.activity.Add(1)
+	if latency := w.cluster.diskLatency(w.identity); latency > 0 {
+		w.clock.Sleep(latency)
+	}
+}
+
+func (w *diskWriter) Stop(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		w.writing.Lock()
+		defer w.writing.Unlock()
+		close(done)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-done:
+		return nil
+	}
+}
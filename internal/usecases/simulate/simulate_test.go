@@ -0,0 +1,118 @@
+package simulate
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestRunWithoutSplitBrain(t *testing.T) {
+	for _, seed := range []uint64{1, 2, 3, 16, 42} {
+		t.Run(fmt.Sprintf("seed %d", seed), func(t *testing.T) {
+			t.Parallel()
+
+			report, err := Run(context.Background(), Config{Seed: seed})
+			if err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			if len(report.Intervals) == 0 {
+				t.Fatal("nobody was elected")
+			}
+			for _, violation := range report.Violations {
+				t.Error(violation)
+			}
+		})
+	}
+}
+
+func TestRunReplaysSeed(t *testing.T) {
+	cfg := Config{Seed: 7, Duration: time.Minute}
+
+	first, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	second, err := Run(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("replay: %v", err)
+	}
+	if !slices.Equal(first.Intervals, second.Intervals) {
+		t.Fatalf("replay led to\n%v\ninstead of\n%v", second.Intervals, first.Intervals)
+	}
+}
+
+// A leader whose clock runs slower than zookeeper.SuspendFraction notices a
+// partition only after its session expired and someone else was elected.
+func TestRunReportsSkewOverlap(t *testing.T) {
+	const timeout = 2 * time.Second
+	report, err := Run(context.Background(), Config{
+		Duration:       20 * time.Second,
+		SessionTimeout: timeout,
+		Faults: []Fault{
+			{At: time.Second, Kind: FaultClockSkew, Replica: 0, Duration: 15 * time.Second, Rate: 0.5},
+			{At: 2 * time.Second, Kind: FaultPartition, Replica: 0, Duration: 5 * time.Second},
+		},
+	})
+	if err != nil {
+		t.Fatalf("run: %v", err)
+	}
+
+	overlaps := Check(report.Intervals, 0)
+	if len(overlaps) != 1 {
+		t.Fatalf("got overlaps %v in %v, want one", overlaps, report.Intervals)
+	}
+	// The skewed leader suspends after 2/3 of the timeout on its clock, i.e.
+	// 4/3 of it in real time, while the server elects another one after the
+	// timeout.
+	overlap := overlaps[0].Overlap
+	if overlap < timeout/3-defaultStep || overlap > timeout/3+2*defaultStep {
+		t.Fatalf("overlap is %v, want about %v", overlap, timeout/3)
+	}
+	if len(report.Violations) != 0 {
+		t.Fatalf("overlap %v within the tolerance reported as %v", overlap, report.Violations)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	intervals := []Interval{
+		{Replica: "replica-0", Epoch: 1, Start: 0, End: 10 * time.Second},
+		{Replica: "replica-1", Epoch: 2, Start: 5 * time.Second, End: 20 * time.Second},
+		{Replica: "replica-0", Epoch: 3, Start: 19 * time.Second, End: 30 * time.Second},
+	}
+
+	tests := []struct {
+		name      string
+		tolerance time.Duration
+		want      []time.Duration
+	}{
+		{
+			name:      "every overlap",
+			tolerance: 0,
+			want:      []time.Duration{5 * time.Second, time.Second},
+		},
+		{
+			name:      "overlap above the tolerance",
+			tolerance: 2 * time.Second,
+			want:      []time.Duration{5 * time.Second},
+		},
+		{
+			name:      "overlaps within the tolerance",
+			tolerance: 5 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := Check(intervals, tt.tolerance)
+			if len(violations) != len(tt.want) {
+				t.Fatalf("got %v, want overlaps %v", violations, tt.want)
+			}
+			for i, violation := range violations {
+				if violation.Overlap != tt.want[i] {
+					t.Errorf("overlap %d is %v, want %v", i, violation.Overlap, tt.want[i])
+				}
+			}
+		})
+	}
+}
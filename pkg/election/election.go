@@ -0,0 +1,384 @@
+// Package election embeds the leader election of the election binary into
+// other Go services. An Elector drives the same Init/Attempter/Leader/
+// Failover/Stopping state machine as the run command and reports leadership
+// changes through callbacks.
+package election
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/commands/cmdargs"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/depgraph"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/extra"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/reload"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/states"
+	"github.com/central-university-dev/2024-spring-go-course-lesson8-leader-election/internal/usecases/run/tasks"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Config holds the same settings as the flags of the run command. New gives
+// the zero fields the defaults of the flags.
+type Config = cmdargs.RunArgs
+
+// Backends accepted in Config.Backend.
+const (
+	BackendZookeeper  = cmdargs.BackendZookeeper
+	BackendEtcd       = cmdargs.BackendEtcd
+	BackendKubernetes = cmdargs.BackendKubernetes
+	BackendPostgres   = cmdargs.BackendPostgres
+	BackendRedis      = cmdargs.BackendRedis
+	BackendRaft       = cmdargs.BackendRaft
+	BackendFileLock   = cmdargs.BackendFileLock
+)
+
+// LeaderTask is work run while this replica leads, see WithLeaderTask.
+type LeaderTask = tasks.LeaderTask
+
+// LeadershipInfo describes the leadership a LeaderTask runs under.
+type LeadershipInfo = tasks.LeadershipInfo
+
+// ErrStepDown can be wrapped by LeaderTask errors to give leadership up
+// instead of stopping the elector.
+var ErrStepDown = tasks.ErrStepDown
+
+// Clock is the source of time of the states, see WithClock.
+type Clock = extra.Clock
+
+// FakeClock is a Clock for tests that only moves on FakeClock.Advance.
+type FakeClock = extra.FakeClock
+
+// NewFakeClock returns a FakeClock standing at now.
+func NewFakeClock(now time.Time) *FakeClock {
+	return extra.NewFakeClock(now)
+}
+
+// defaultObservePeriod is used to poll the leader identity when
+// Config.RetryPeriod is not set.
+const defaultObservePeriod = time.Second
+
+// ErrNotLeader is returned by Resign when this replica does not lead.
+var ErrNotLeader = errors.New("not the leader")
+
+// ErrNotLive is wrapped by Reload errors caused by settings that need a
+// restart to change.
+var ErrNotLive = reload.ErrNotLive
+
+// Callbacks are invoked on leadership changes. Every callback is optional.
+type Callbacks struct {
+	// OnStartedLeading runs in its own goroutine once this replica becomes
+	// the leader. ctx is cancelled the moment leadership is lost.
+	OnStartedLeading func(ctx context.Context)
+	// OnStoppedLeading is called once this replica is no longer the leader.
+	OnStoppedLeading func()
+	// OnNewLeader is called with the identity of every newly observed
+	// leader, including this replica.
+	OnNewLeader func(identity string)
+}
+
+type Option func(*Elector)
+
+// WithLogger makes the elector and its states log through logger.
+func WithLogger(logger *slog.Logger) Option {
+	return func(e *Elector) {
+		e.dg.WithLogger(logger)
+	}
+}
+
+// WithClock makes the states, the leader tasks and the runner measure time
+// with clock instead of the real one.
+func WithClock(clock Clock) Option {
+	return func(e *Elector) {
+		e.dg.WithClock(clock)
+	}
+}
+
+// WithLeaderTask makes the leader run task next to the tasks listed in
+// Config.LeaderTasks.
+func WithLeaderTask(task LeaderTask) Option {
+	return func(e *Elector) {
+		e.dg.WithLeaderTasks(task)
+	}
+}
+
+// WithListenAddr makes the elector serve the metrics, the admin API and the
+// probes over HTTP on addr. Without it, or with an empty addr, there is no
+// HTTP server.
+func WithListenAddr(addr string) Option {
+	return func(e *Elector) {
+		e.listenAddr = addr
+	}
+}
+
+// WithRegistry makes the elector register its metrics in registry, which the
+// HTTP server serves on /metrics when it is a prometheus.Gatherer. Without it
+// the metrics go to a registry of the elector's own.
+func WithRegistry(registry prometheus.Registerer) Option {
+	return func(e *Elector) {
+		e.registry = registry
+	}
+}
+
+// New returns an elector for cfg, or an error listing every invalid setting.
+func New(cfg Config, callbacks Callbacks, opts ...Option) (*Elector, error) {
+	cfg = cfg.WithDefaults()
+	err := cfg.Validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid config: %w", err)
+	}
+
+	e := &Elector{
+		cfg:       cfg,
+		callbacks: callbacks,
+		dg:        depgraph.New(),
+		identity:  depgraph.Identity(cfg),
+	}
+	for _, opt := range opts {
+		opt(e)
+	}
+	if e.registry == nil {
+		// Metrics of an embedded elector stay out of the global registry of
+		// the service.
+		e.registry = prometheus.NewRegistry()
+	}
+	e.dg.WithRegistry(e.registry)
+	return e, nil
+}
+
+// Elector takes part in the election until Run returns.
+type Elector struct {
+	cfg       Config
+	callbacks Callbacks
+	dg        *depgraph.DepGraph
+	identity  string
+	// listenAddr is the address of the HTTP server, none is run when empty.
+	listenAddr string
+	registry   prometheus.Registerer
+
+	mu       sync.Mutex
+	leading  bool
+	leader   string
+	cancel   context.CancelFunc
+	resigned chan struct{}
+}
+
+// Run campaigns for leadership until ctx is done or the state machine stops.
+// The HTTP server is shut down before it returns. It must be called once per
+// Elector.
+func (e *Elector) Run(ctx context.Context) error {
+	runner, err := e.dg.GetRunner()
+	if err != nil {
+		return fmt.Errorf("get runner: %w", err)
+	}
+	runner.AddHook(e.onTransition)
+	runner.SetAddr(e.listenAddr)
+
+	dispatcher, err := e.dg.GetHookDispatcher(e.cfg)
+	if err != nil {
+		return fmt.Errorf("get hook dispatcher: %w", err)
+	}
+
+	adminAPI, err := e.dg.GetAdminAPI(e.cfg)
+	if err != nil {
+		return fmt.Errorf("get admin api: %w", err)
+	}
+	adminAPI.Mount(runner)
+
+	probes, err := e.dg.GetProbes(e.cfg)
+	if err != nil {
+		return fmt.Errorf("get probes: %w", err)
+	}
+	probes.Mount(runner)
+
+	firstState, err := e.dg.GetInitState(e.cfg)
+	if err != nil {
+		return fmt.Errorf("get first state: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	go e.observeLeader(ctx)
+
+	if !dispatcher.Empty() {
+		runner.AddHook(dispatcher.OnTransition)
+
+		// Wait for the hooks of the final transitions before returning.
+		hooksDone := make(chan struct{})
+		go func() {
+			defer close(hooksDone)
+			dispatcher.Run(ctx)
+		}()
+		defer func() {
+			cancel()
+			<-hooksDone
+		}()
+	}
+
+	err = runner.Run(ctx, firstState)
+	if err != nil {
+		return fmt.Errorf("run states: %w", err)
+	}
+	return nil
+}
+
+// Reload applies cfg to the running elector. Only LeaderTimeout,
+// AttempterTimeout and StorageCapacity may differ from the current config,
+// other changes are rejected with an error wrapping ErrNotLive. The zero
+// fields of cfg take the defaults as in New.
+func (e *Elector) Reload(cfg Config) error {
+	reloader, err := e.dg.GetReloader(e.cfg)
+	if err != nil {
+		return fmt.Errorf("get reloader: %w", err)
+	}
+	return reloader.Apply(cfg.WithDefaults())
+}
+
+// ReloadFailed counts a reload that failed before a Config was built.
+func (e *Elector) ReloadFailed(err error) {
+	reloader, getErr := e.dg.GetReloader(e.cfg)
+	if getErr != nil {
+		return
+	}
+	reloader.Fail(err)
+}
+
+// IsLeader reports whether this replica currently leads.
+func (e *Elector) IsLeader() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leading
+}
+
+// Leader returns the identity of the last observed leader, or an empty
+// string when none is known.
+func (e *Elector) Leader() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.leader
+}
+
+// Identity returns the name this replica campaigns under.
+func (e *Elector) Identity() string {
+	return e.identity
+}
+
+// Resign gives leadership up and waits until the leader state is left. The
+// replica keeps campaigning afterwards.
+func (e *Elector) Resign(ctx context.Context) error {
+	e.mu.Lock()
+	leading, resigned := e.leading, e.resigned
+	e.mu.Unlock()
+
+	if !leading {
+		return ErrNotLeader
+	}
+
+	leaderState, err := e.dg.GetLeaderState(e.cfg)
+	if err != nil {
+		return fmt.Errorf("get leader state: %w", err)
+	}
+	leaderState.Resign()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resigned:
+		return nil
+	}
+}
+
+func (e *Elector) onTransition(ctx context.Context, from, to run.AutomataState) {
+	_, wasLeader := from.(*states.LeaderState)
+	_, isLeader := to.(*states.LeaderState)
+
+	switch {
+	case isLeader && !wasLeader:
+		e.startLeading(ctx)
+	case wasLeader && !isLeader:
+		e.stopLeading()
+	}
+}
+
+func (e *Elector) startLeading(ctx context.Context) {
+	leaderCtx, cancel := context.WithCancel(ctx)
+
+	e.mu.Lock()
+	e.leading, e.cancel, e.resigned = true, cancel, make(chan struct{})
+	e.mu.Unlock()
+
+	e.setLeader(e.identity)
+
+	if e.callbacks.OnStartedLeading != nil {
+		go e.callbacks.OnStartedLeading(leaderCtx)
+	}
+}
+
+func (e *Elector) stopLeading() {
+	e.mu.Lock()
+	cancel, resigned := e.cancel, e.resigned
+	e.leading, e.leader, e.cancel, e.resigned = false, "", nil, nil
+	e.mu.Unlock()
+
+	cancel()
+	close(resigned)
+
+	if e.callbacks.OnStoppedLeading != nil {
+		e.callbacks.OnStoppedLeading()
+	}
+}
+
+func (e *Elector) setLeader(identity string) {
+	e.mu.Lock()
+	changed := e.leader != identity
+	e.leader = identity
+	e.mu.Unlock()
+
+	if changed && identity != "" && e.callbacks.OnNewLeader != nil {
+		e.callbacks.OnNewLeader(identity)
+	}
+}
+
+// observeLeader polls the coordinator for the leader identity, since only
+// the leader itself learns about leadership from the state machine.
+func (e *Elector) observeLeader(ctx context.Context) {
+	coordinator, err := e.dg.GetCoordinator(e.cfg)
+	if err != nil {
+		return
+	}
+
+	clock, err := e.dg.GetClock()
+	if err != nil {
+		return
+	}
+
+	period := e.cfg.RetryPeriod
+	if period <= 0 {
+		period = defaultObservePeriod
+	}
+	ticker := clock.NewTicker(period)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.Chan():
+		}
+
+		if e.IsLeader() || !coordinator.Connected() {
+			continue
+		}
+
+		leader, err := coordinator.Leader(ctx)
+		if err != nil {
+			continue
+		}
+		e.setLeader(leader)
+	}
+}
@@ -0,0 +1,67 @@
+package election
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// leadOnce runs an elector on a lock file until it leads and stops it.
+func leadOnce(t *testing.T, opts ...Option) {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	elector, err := New(Config{
+		Backend:  BackendFileLock,
+		LockFile: filepath.Join(t.TempDir(), "election.lock"),
+		Identity: "replica",
+	}, Callbacks{
+		OnStartedLeading: func(context.Context) { cancel() },
+	}, append(opts, WithLogger(slog.New(slog.NewTextHandler(io.Discard, nil))))...)
+	if err != nil {
+		t.Fatalf("new elector: %v", err)
+	}
+	if err := elector.Run(ctx); err != nil && !errors.Is(err, context.Canceled) {
+		t.Fatalf("run: %v", err)
+	}
+}
+
+func registered(t *testing.T, gatherer prometheus.Gatherer, name string) bool {
+	t.Helper()
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		t.Fatalf("gather: %v", err)
+	}
+	for _, family := range families {
+		if family.GetName() == name {
+			return true
+		}
+	}
+	return false
+}
+
+func TestMetricsGoToRegistry(t *testing.T) {
+	registry := prometheus.NewRegistry()
+	leadOnce(t, WithRegistry(registry))
+
+	if !registered(t, registry, "state_changes_total") {
+		t.Fatal("state metrics are not in the given registry")
+	}
+}
+
+func TestMetricsStayOutOfGlobalRegistry(t *testing.T) {
+	leadOnce(t)
+
+	if registered(t, prometheus.DefaultGatherer, "state_changes_total") {
+		t.Fatal("state metrics are in the global registry")
+	}
+}